@@ -80,6 +80,37 @@ func standardValidator(_ *exec.Cmd, waitError error) error {
 	return waitError
 }
 
+func anyStatusValidator(actualStatus *int) commandValidator {
+	return func(cmd *exec.Cmd, waitError error) error {
+		status := extractStatusCode(waitError)
+		if status < 0 {
+			return waitError
+		}
+		if actualStatus != nil {
+			*actualStatus = status
+		}
+		return nil
+	}
+}
+
+func statusValidator(actualStatus *int, allowed []int) commandValidator {
+	return func(cmd *exec.Cmd, waitError error) error {
+		status := extractStatusCode(waitError)
+		if status < 0 {
+			return waitError
+		}
+		if actualStatus != nil {
+			*actualStatus = status
+		}
+		for _, a := range allowed {
+			if a == status {
+				return nil
+			}
+		}
+		return waitError
+	}
+}
+
 func ensureNilAndSet[T any](a *T, value T, format string, args ...any) {
 	if a == nil {
 		panic(fmt.Sprintf(format, args...))
@@ -117,12 +148,48 @@ type CommandChain struct {
 	Commands     []*exec.Cmd
 	validators   []commandValidator
 	stderrReader []*BytesReader
+	transcripts  []*Transcript
+
+	// outputClosers are closed after each command finishes, to flush its output.
+	outputClosers [][]io.Closer
+
+	// closers are closed when the chain finishes.
+	closers []io.Closer
+
+	// pipeReaders are the read ends of the pipes between the commands.
+	pipeReaders []io.Closer
+
+	// lastFailure is the failure of the rightmost failed command, which is set by Wait.
+	lastFailure *CommandError
 
 	tempFiles []*os.File
 
 	cleanupMu sync.Mutex
 }
 
+// CommandError is returned by ChainWaiter.Wait and GraphWaiter.Wait when a command fails.
+type CommandError struct {
+	// Index is the index of the failed command in the chain or the graph.
+	Index int
+
+	// Name is the command name in a Graph. Empty for a CommandChain.
+	Name string
+
+	Cmd *exec.Cmd
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("failed to wait on command %s (command \"%s\"): %s", e.Cmd.Path, e.Name, e.Err)
+	}
+	return fmt.Sprintf("failed to wait on command %s: %s", e.Cmd.Path, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
 // ChainWaiter is a handle that can be wait()'ed on.
 type ChainWaiter struct {
 	Chain *CommandChain
@@ -147,10 +214,14 @@ func WithStdIn(reader io.Reader) *CommandChain {
 }
 
 // WithStdInFile creates a new CommandChain, with a given file as stdin.
+// If the file has an extension of a registered Compressor, it's decompressed in-process.
 func WithStdInFile(filename string) *CommandChain {
-	in, err := openForRead(filename)
+	in, closer, err := openInputFile(filename)
+	if err != nil {
+		return New().setDeferredError(err)
+	}
 	ret := WithStdIn(in)
-	ret.setDeferredError(err)
+	ret.closers = append(ret.closers, closer)
 	return ret
 }
 
@@ -202,6 +273,28 @@ func (c *CommandChain) cleanUp() {
 		_ = f.Close()
 		_ = os.Remove(f.Name())
 	}
+	for i := range c.outputClosers {
+		c.closeOutputs(i)
+	}
+	for _, cl := range c.closers {
+		_ = cl.Close()
+	}
+	c.closers = nil
+	for _, t := range c.transcripts {
+		t.finish()
+	}
+}
+
+// closeOutputs closes the output closers of the command at index, and returns the first error.
+func (c *CommandChain) closeOutputs(index int) error {
+	var firstError error
+	for _, cl := range c.outputClosers[index] {
+		if err := cl.Close(); err != nil && firstError == nil {
+			firstError = err
+		}
+	}
+	c.outputClosers[index] = nil
+	return firstError
 }
 
 func (c *CommandChain) ensureHasCommand() {
@@ -256,6 +349,16 @@ func (c *CommandChain) setDeferredError(err error) *CommandChain {
 	return c
 }
 
+// setDeferredErrorQuietly is the same as setDeferredError, except it doesn't print a warning,
+// for errors the caller is expected to handle, such as a missing command.
+func (c *CommandChain) setDeferredErrorQuietly(err error) *CommandChain {
+	if c.deferredError == nil && err != nil {
+		common.Debugf("Error detected: %v", err)
+		c.deferredError = err
+	}
+	return c
+}
+
 func (c *CommandChain) lastCommand() *exec.Cmd {
 	c.ensureHasCommand()
 	return c.Commands[len(c.Commands)-1]
@@ -266,7 +369,8 @@ func (c *CommandChain) Command(name string, args ...string) *CommandChain {
 	c.ensureBuilding()
 	common.Debugf("Command: %s", name)
 
-	cmd := exec.Command(name, args...)
+	cmd, err := newCmd(name, args...)
+	c.setDeferredErrorQuietly(err)
 
 	if len(c.Commands) > 0 {
 		c.fixUpLastCommand()
@@ -275,6 +379,7 @@ func (c *CommandChain) Command(name string, args ...string) *CommandChain {
 	c.Commands = append(c.Commands, cmd)
 	c.validators = append(c.validators, nil)
 	c.stderrReader = append(c.stderrReader, nil)
+	c.outputClosers = append(c.outputClosers, nil)
 
 	if c.nextStdin != nil {
 		cmd.Stdin = c.nextStdin
@@ -314,16 +419,7 @@ func (c *CommandChain) setValidator(validator commandValidator) {
 // AllowAnyStatus will allow the previous command to return any exit status code.
 func (c *CommandChain) AllowAnyStatus(actualStatus *int) *CommandChain {
 	c.ensureBuilding()
-	c.setValidator(func(cmd *exec.Cmd, waitError error) error {
-		status := extractStatusCode(waitError)
-		if status < 0 {
-			return waitError
-		}
-		if actualStatus != nil {
-			*actualStatus = status
-		}
-		return nil
-	})
+	c.setValidator(anyStatusValidator(actualStatus))
 	return c
 }
 
@@ -334,21 +430,7 @@ func (c *CommandChain) AllowStatus(actualStatus *int, allowed ...int) *CommandCh
 	if len(allowed) == 0 {
 		panic("AllowStatus expects 1 or more allowed status codes.")
 	}
-	c.setValidator(func(cmd *exec.Cmd, waitError error) error {
-		status := extractStatusCode(waitError)
-		if status < 0 {
-			return waitError
-		}
-		if actualStatus != nil {
-			*actualStatus = status
-		}
-		for _, a := range allowed {
-			if a == status {
-				return nil
-			}
-		}
-		return waitError
-	})
+	c.setValidator(statusValidator(actualStatus, allowed))
 	return c
 }
 
@@ -391,24 +473,22 @@ func (c *CommandChain) ErrToOut() *CommandChain {
 }
 
 // SetStdoutFile sets a file to the stdout of the last command.
+// If the file has an extension of a registered Compressor, the output is compressed in-process.
 func (c *CommandChain) SetStdoutFile(filename string) *CommandChain {
 	c.ensureBuilding()
-	f, err := openForWrite(filename)
-	if err == nil {
-		c.SetStdout(io.Writer(f))
+	if wr := c.openOutputFile(filename); wr != nil {
+		c.SetStdout(wr)
 	}
-	c.setDeferredError(err)
 	return c
 }
 
 // SetStderrFile sets a file to the stderr of the last command.
+// If the file has an extension of a registered Compressor, the output is compressed in-process.
 func (c *CommandChain) SetStderrFile(filename string) *CommandChain {
 	c.ensureBuilding()
-	f, err := openForWrite(filename)
-	if err == nil {
-		c.SetStderr(io.Writer(f))
+	if wr := c.openOutputFile(filename); wr != nil {
+		c.SetStderr(wr)
 	}
-	c.setDeferredError(err)
 	return c
 }
 
@@ -453,6 +533,7 @@ func (c *CommandChain) Pipe() *CommandChain {
 	var rd *io.ReadCloser
 	c.getStdoutPipe(&rd)
 	c.setNextStdin(*rd)
+	c.pipeReaders = append(c.pipeReaders, *rd)
 	return c
 }
 
@@ -485,6 +566,14 @@ func (c *CommandChain) Run() (*ChainWaiter, error) {
 			return nil, fmt.Errorf("unable to execute command \"%s\" (command #%d): %s", cmd.Path, i+1, err.Error())
 		}
 	}
+
+	// Now the children have their own copies of the pipes. Close ours, so upstream commands
+	// will get SIGPIPE when downstream commands exit.
+	for _, p := range c.pipeReaders {
+		_ = p.Close()
+	}
+	c.pipeReaders = nil
+
 	return &ChainWaiter{Chain: c}, nil
 }
 
@@ -646,6 +735,17 @@ func (c *CommandChain) MustRunAndStreamStringsIter() *utils.Iterator[string] {
 	)
 }
 
+func readSavedStderr(cmd *exec.Cmd) ([]byte, error) {
+	errf := cmd.Stderr.(*os.File)
+	errf.Seek(0, 0)
+
+	data, err := io.ReadAll(errf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from tempfile %s: %w", errf.Name(), err)
+	}
+	return data, nil
+}
+
 // Wait wait() on all commands in a CommandChain.
 func (cw *ChainWaiter) Wait() (*ChainResult, error) {
 	cw.Chain.moveToWaiting()
@@ -655,23 +755,32 @@ func (cw *ChainWaiter) Wait() (*ChainResult, error) {
 		err := cmd.Wait()
 		err = cw.Chain.validators[i](cmd, err)
 
+		if cerr := cw.Chain.closeOutputs(i); cerr != nil && err == nil {
+			err = cerr
+		}
+
+		ser := cw.Chain.stderrReader[i]
+
 		if err != nil {
+			cw.Chain.lastFailure = &CommandError{Index: i, Cmd: cmd, Err: err}
 			if firstError == nil {
-				firstError = fmt.Errorf("failed to wait on command %s: %w", cmd.Path, err)
+				firstError = cw.Chain.lastFailure
+			}
+			// Still make the saved stderr available, for error reporting.
+			if ser != nil {
+				if data, rerr := readSavedStderr(cmd); rerr == nil {
+					ser.data = data
+				}
 			}
 			continue
 		}
 
 		// See if there's any stderr consumers.
-		ser := cw.Chain.stderrReader[i]
 		if ser != nil {
-			errf := cmd.Stderr.(*os.File)
-			errf.Seek(0, 0)
-
-			data, err := io.ReadAll(errf)
+			data, err := readSavedStderr(cmd)
 			if err != nil {
 				if firstError == nil {
-					firstError = fmt.Errorf("failed to read from tempfile %s: %w", errf.Name(), err)
+					firstError = err
 					continue
 				}
 			}
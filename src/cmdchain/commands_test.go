@@ -1,10 +1,13 @@
 package cmdchain
 
 import (
+	"bytes"
 	"github.com/omakoto/go-common/src/common"
+	"github.com/omakoto/go-common/src/common/commontest"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"os"
+	"os/exec"
 	"testing"
 )
 
@@ -276,3 +279,232 @@ func TestBasic(t *testing.T) {
 	//	assert.LessOrEqualf(t, time.Duration(500_000_000), end.Sub(start), "Didn't received the strings 500ms apart. (start=%s, end=%s)", start, end)
 	//}
 }
+
+func TestCapture(t *testing.T) {
+	{
+		tr := NewTranscript()
+		New().Command("bash", "-c", "echo out1; sleep 0.1; echo err1 1>&2; sleep 0.1; echo -n out2").Capture(tr).MustRunAndWait()
+
+		entries := tr.Entries()
+		assert.Equal(t, 3, len(entries))
+		assert.Equal(t, StreamStdout, entries[0].Stream)
+		assert.Equal(t, "out1", entries[0].Text())
+		assert.Equal(t, StreamStderr, entries[1].Stream)
+		assert.Equal(t, "err1", entries[1].Text())
+		assert.Equal(t, StreamStdout, entries[2].Stream)
+		assert.Equal(t, "out2", entries[2].Text())
+		assert.LessOrEqual(t, entries[0].Elapsed, entries[1].Elapsed)
+		assert.LessOrEqual(t, entries[1].Elapsed, entries[2].Elapsed)
+
+		assert.Regexp(t, `^\[ *[0-9.]+\] stdout: out1\n\[ *[0-9.]+\] stderr: err1\n\[ *[0-9.]+\] stdout: out2\n$`, tr.String())
+
+		js, err := tr.JSON()
+		assert.NoError(t, err)
+		assert.Contains(t, string(js), `"stream":"stderr"`)
+		assert.Contains(t, string(js), `"text":"err1"`)
+	}
+
+	{
+		// The transcript is available even if the command fails.
+		tr := NewTranscript()
+		_, err := New().Command("bash", "-c", "echo failing 1>&2; exit 3").Capture(tr).MustRun().Wait()
+		assert.Equal(t, 3, extractStatusCode(err))
+		assert.Equal(t, "failing", tr.Entries()[0].Text())
+	}
+
+	{
+		assert.PanicsWithValue(t, "CommandChain hasn't been waited yet.", func() {
+			tr := NewTranscript()
+			New().Command("true").Capture(tr)
+			tr.Entries()
+		}, "Expected panic")
+	}
+}
+
+func TestCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	{
+		gz := dir + "/out.txt.gz"
+		WithStdInString("abc\ndef\n").Command("cat").SetStdoutFile(gz).MustRunAndWait()
+
+		out := New().Command("gzip", "-dc", gz).MustRunAndGetString()
+		assert.Equal(t, "abc\ndef\n", out)
+
+		out = WithStdInFile(gz).Command("cat", "-An").MustRunAndGetString()
+		assert.Equal(t, "     1\tabc$\n     2\tdef$\n", out)
+	}
+
+	{
+		gz := dir + "/err.gz"
+		New().Command("bash", "-c", "echo err 1>&2").SetStderrFile(gz).MustRunAndWait()
+
+		out := WithStdInFile(gz).Command("cat").MustRunAndGetString()
+		assert.Equal(t, "err\n", out)
+	}
+
+	{
+		// Non-compressed files are handled as-is.
+		plain := dir + "/out.txt"
+		WithStdInString("abc\n").Command("cat").SetStdoutFile(plain).MustRunAndWait()
+		assert.Equal(t, "abc\n", mustReadAllFileAsString(plain))
+
+		out := WithStdInFile(plain).Command("cat").MustRunAndGetString()
+		assert.Equal(t, "abc\n", out)
+	}
+
+	{
+		notGz := mustMakeTempFile("not compressed")
+		bad := dir + "/bad.gz"
+		assert.NoError(t, os.Rename(notGz, bad))
+		_, err := WithStdInFile(bad).Command("cat").Run()
+		assert.ErrorContains(t, err, "unable to decompress file")
+	}
+}
+
+func TestCommandNotFound(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"foobar", "foobaz", "unrelated"} {
+		assert.NoError(t, os.WriteFile(dir+"/"+name, []byte("#!/bin/sh\necho "+name+"\n"), 0755))
+	}
+	t.Setenv("PATH", dir)
+
+	{
+		_, err := New().Command("fooabr").Run()
+		var nf *CommandNotFoundError
+		assert.ErrorAs(t, err, &nf)
+		assert.ErrorIs(t, err, exec.ErrNotFound)
+		assert.Equal(t, []string{"foobar"}, nf.Suggestions)
+		assert.EqualError(t, err, `command "fooabr" not found in PATH (did you mean "foobar"?)`)
+	}
+
+	{
+		_, err := New().Command("fooba").Run()
+		assert.EqualError(t, err, `command "fooba" not found in PATH (did you mean "foobar", "foobaz"?)`)
+	}
+
+	{
+		_, err := New().Command("xyz").Run()
+		assert.EqualError(t, err, `command "xyz" not found in PATH`)
+	}
+
+	{
+		out := New().Command("foobar").MustRunAndGetString()
+		assert.Equal(t, "foobar\n", out)
+	}
+
+	{
+		PinCommand("xyz", dir+"/unrelated")
+		defer UnpinCommand("xyz")
+		out := New().Command("xyz").MustRunAndGetString()
+		assert.Equal(t, "unrelated\n", out)
+	}
+
+	{
+		// Missing commands are reported only through the returned error.
+		var log bytes.Buffer
+		common.SetLogOutput(&log)
+		defer common.SetLogOutput(nil)
+		_, err := New().Command("newcmd").Run()
+		assert.ErrorIs(t, err, exec.ErrNotFound)
+		_, err = NewGraph().Command("a", "newcmd").Run()
+		assert.ErrorIs(t, err, exec.ErrNotFound)
+		assert.Equal(t, "", log.String())
+
+		// Failed lookups aren't cached, so a command installed later is found.
+		assert.NoError(t, os.WriteFile(dir+"/newcmd", []byte("#!/bin/sh\necho new\n"), 0755))
+		assert.Equal(t, "new\n", New().Command("newcmd").MustRunAndGetString())
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	{
+		_, err := New().Command("bash", "-c", "exit 7").MustRun().Wait()
+		assert.Equal(t, 7, ExitStatusOf(err))
+	}
+
+	{
+		_, err := New().Command("bash", "-c", "kill -TERM $$").MustRun().Wait()
+		assert.Equal(t, 128+15, ExitStatusOf(err))
+	}
+
+	{
+		_, err := New().Command("bash", "-c", "exit 0").Pipe().Command("bash", "-c", "exit 4").MustRun().Wait()
+		var ce *CommandError
+		assert.ErrorAs(t, err, &ce)
+		assert.Equal(t, 1, ce.Index)
+		assert.Equal(t, 4, ExitStatusOf(err))
+	}
+
+	{
+		erd := NewBytesReader()
+		_, err := New().Command("bash", "-c", "echo err 1>&2; exit 1").SaveStderr(erd).MustRun().Wait()
+		assert.Equal(t, 1, ExitStatusOf(err))
+		assert.Equal(t, "err\n", string(erd.Get()))
+	}
+
+	assert.Equal(t, -1, ExitStatusOf(nil))
+
+	New().Command("true").MustRunAndExitOnFailure(true)
+
+	{
+		r := commontest.RunWithOptions(t, commontest.Options{BinName: "test"}, func() int {
+			New().Command("false").MustRunAndExitOnFailure(true)
+			return 0
+		})
+		assert.Equal(t, 1, r.Status)
+		assert.Equal(t, "", r.Stderr)
+	}
+
+	{
+		// The saved stderr of the failed command is printed, and its status is passed through.
+		r := commontest.RunWithOptions(t, commontest.Options{BinName: "test"}, func() int {
+			erd := NewBytesReader()
+			New().Command("bash", "-c", "echo out; exit 0").SaveStderr(NewBytesReader()).
+				Pipe().Command("bash", "-c", "cat >/dev/null; echo line1 1>&2; echo line2 1>&2; exit 42").SaveStderr(erd).
+				MustRunAndExitOnFailure(true)
+			return 0
+		})
+		assert.Equal(t, 42, r.Status)
+		assert.Equal(t, "test: line1\ntest: line2\n", r.Stderr)
+	}
+
+	{
+		// The rightmost failure is used, not the SIGPIPE of the upstream commands.
+		r := commontest.RunWithOptions(t, commontest.Options{BinName: "test"}, func() int {
+			New().Command("yes").Pipe().Command("head", "-1").Pipe().Command("bash", "-c", "exit 5").
+				MustRunAndExitOnFailure(true)
+			return 0
+		})
+		assert.Equal(t, 5, r.Status)
+		assert.Equal(t, "", r.Stderr)
+	}
+
+	{
+		r := commontest.RunWithOptions(t, commontest.Options{BinName: "test"}, func() int {
+			erd := NewBytesReader()
+			New().Command("bash", "-c", "echo err 1>&2; exit 3").SaveStderr(erd).MustRunAndExitOnFailure(false)
+			return 0
+		})
+		assert.Equal(t, 3, r.Status)
+		assert.Equal(t, "", r.Stderr)
+	}
+
+	{
+		r := commontest.RunWithOptions(t, commontest.Options{BinName: "test"}, func() int {
+			New().Command("bash", "-c", "kill -TERM $$").MustRunAndExitOnFailure(true)
+			return 0
+		})
+		assert.Equal(t, 128+15, r.Status)
+	}
+
+	{
+		t.Setenv("PATH", t.TempDir())
+		r := commontest.RunWithOptions(t, commontest.Options{BinName: "test"}, func() int {
+			New().Command("nonexistent-command").MustRunAndExitOnFailure(true)
+			return 0
+		})
+		assert.Equal(t, StatusNotFound, r.Status)
+		assert.Equal(t, "test: command \"nonexistent-command\" not found in PATH\n", r.Stderr)
+	}
+}
@@ -0,0 +1,111 @@
+package cmdchain
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Compressor converts streams from / to a compressed format.
+type Compressor struct {
+	NewReader func(rd io.Reader) (io.ReadCloser, error)
+	NewWriter func(wr io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	compressorsMu sync.Mutex
+	compressors   = map[string]Compressor{
+		".gz": {
+			NewReader: func(rd io.Reader) (io.ReadCloser, error) {
+				return gzip.NewReader(rd)
+			},
+			NewWriter: func(wr io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(wr), nil
+			},
+		},
+	}
+)
+
+// RegisterCompressor registers a Compressor for a filename extension (e.g. ".zst"), which
+// will be used by WithStdInFile, SetStdoutFile and SetStderrFile.
+// ".gz" is supported by default.
+func RegisterCompressor(ext string, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[strings.ToLower(ext)] = c
+}
+
+func findCompressor(filename string) (Compressor, bool) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	c, ok := compressors[strings.ToLower(filepath.Ext(filename))]
+	return c, ok
+}
+
+// closerFunc adapts a function to io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// chainCloser closes all the closers in order and returns the first error.
+func chainCloser(closers ...io.Closer) io.Closer {
+	return closerFunc(func() error {
+		var firstError error
+		for _, c := range closers {
+			if err := c.Close(); err != nil && firstError == nil {
+				firstError = err
+			}
+		}
+		return firstError
+	})
+}
+
+// openInputFile opens a file for reading. If the file has an extension of a registered
+// Compressor, the returned reader decompresses it in-process.
+func openInputFile(filename string) (io.Reader, io.Closer, error) {
+	in, err := openForRead(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	comp, ok := findCompressor(filename)
+	if !ok {
+		return in, in, nil
+	}
+	rd, err := comp.NewReader(in)
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, fmt.Errorf("unable to decompress file %s: %w", filename, err)
+	}
+	return rd, chainCloser(rd, in), nil
+}
+
+// openOutputFile opens a file for writing. If the file has an extension of a registered
+// Compressor, the returned writer compresses the output in-process. Returns nil on error,
+// which is set as the deferred error.
+func (c *CommandChain) openOutputFile(filename string) io.Writer {
+	comp, compressed := findCompressor(filename)
+	if compressed {
+		c.ensureHasCommand()
+	}
+	f, err := openForWrite(filename)
+	if err != nil {
+		c.setDeferredError(err)
+		return nil
+	}
+	if !compressed {
+		return f
+	}
+	wr, err := comp.NewWriter(f)
+	if err != nil {
+		_ = f.Close()
+		c.setDeferredError(fmt.Errorf("unable to compress to file %s: %w", filename, err))
+		return nil
+	}
+	arraySet(c.outputClosers, -1, append(arrayGet(c.outputClosers, -1), chainCloser(wr, f)))
+	return wr
+}
@@ -0,0 +1,72 @@
+package cmdchain
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+
+	"github.com/omakoto/go-common/src/common"
+)
+
+const (
+	// StatusCannotExecute is the exit status used when a command can't be started.
+	StatusCannotExecute = 126
+
+	// StatusNotFound is the exit status used when a command can't be found.
+	StatusNotFound = 127
+)
+
+// ExitStatusOf returns the exit status of the command that caused err, the same way shells do:
+// the exit code if the command exited, or 128 + the signal number if it was killed by a signal.
+// It returns -1 if err isn't caused by a command exit.
+func ExitStatusOf(err error) int {
+	var e *exec.ExitError
+	if !errors.As(err, &e) {
+		return -1
+	}
+	if ws, ok := e.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return e.ExitCode()
+}
+
+// MustRunAndExitOnFailure starts a CommandChain and wait()s on it. If any of the commands fails,
+// it finishes the process with common.ExitWithStatus, using the exit status of the rightmost
+// failed command, like shells with "set -o pipefail" do, so it should be used within
+// common.RunAndExit. (Upstream commands may have been killed by SIGPIPE because of the failure,
+// which is why it's not the first failure, unlike the error returned by ChainWaiter.Wait.)
+// If printStderr is true and the failed command's stderr was saved with SaveStderr, it's printed
+// with common.Warn.
+func (c *CommandChain) MustRunAndExitOnFailure(printStderr bool) *ChainResult {
+	cw, err := c.Run()
+	if err != nil {
+		common.Warn(err.Error())
+		if errors.Is(err, exec.ErrNotFound) {
+			common.ExitWithStatus(StatusNotFound)
+		}
+		common.ExitWithStatus(StatusCannotExecute)
+	}
+
+	cr, err := cw.Wait()
+	if err == nil {
+		return cr
+	}
+
+	if c.lastFailure != nil {
+		err = c.lastFailure
+	}
+	var ce *CommandError
+	if printStderr && errors.As(err, &ce) {
+		if ser := c.stderrReader[ce.Index]; ser != nil && len(ser.data) > 0 {
+			common.Warn(string(ser.data))
+		}
+	}
+
+	status := ExitStatusOf(err)
+	if status < 0 {
+		common.Warn(err.Error())
+		status = 1
+	}
+	common.ExitWithStatus(status)
+	return nil
+}
@@ -0,0 +1,473 @@
+package cmdchain
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+
+	"github.com/omakoto/go-common/src/common"
+)
+
+type graphNode struct {
+	name      string
+	cmd       *exec.Cmd
+	validator commandValidator
+
+	inputs  []*graphNode
+	outputs []*graphNode
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	// Write end of the pipe feeding stdin, and the number of holders that need to
+	// release it before it's closed. Set up in Run().
+	pipeW    *os.File
+	pipeRefs int
+}
+
+// Graph is a set of named commands connected with pipes. Unlike CommandChain, the stdout of
+// a command may be fed to multiple commands (fan-out), and the stdout of multiple commands
+// may be merged into the stdin of a single command (fan-in).
+// Merged output is interleaved in the order the producers write it. If a consumer of fan-out
+// exits early, e.g. "head", the other consumers still get the entire output.
+type Graph struct {
+	state int32
+
+	deferredError error
+
+	nodes  []*graphNode
+	byName map[string]*graphNode
+
+	// Parent side pipe ends that need to be closed.
+	files []*os.File
+
+	cleanupMu sync.Mutex
+}
+
+// GraphWaiter is a handle that can be wait()'ed on.
+type GraphWaiter struct {
+	Graph *Graph
+}
+
+// GraphResult provides the overall result of the command graph.
+type GraphResult struct {
+	Graph *Graph
+}
+
+// NewGraph creates a new Graph.
+func NewGraph() *Graph {
+	return &Graph{byName: make(map[string]*graphNode)}
+}
+
+func (g *Graph) ensureBuilding() {
+	if atomic.LoadInt32(&(g.state)) != StateBuilding {
+		panic("Invalid operation on Graph. It's already running.")
+	}
+}
+
+func (g *Graph) moveToRunning() {
+	if !atomic.CompareAndSwapInt32(&(g.state), StateBuilding, StateRunning) {
+		panic("Invalid operation Run() on Graph. It's already running.")
+	}
+}
+
+func (g *Graph) moveToWaiting() {
+	if !atomic.CompareAndSwapInt32(&(g.state), StateRunning, StateWaiting) {
+		panic("Invalid operation Wait() on Graph. It's not running.")
+	}
+}
+
+func (g *Graph) moveToSucceeded() {
+	if !atomic.CompareAndSwapInt32(&(g.state), StateWaiting, StateSucceeded) {
+		panic("Invalid operation Wait() on Graph. It's not running.")
+	}
+	g.cleanUp()
+}
+
+func (g *Graph) moveToFailed() {
+	atomic.StoreInt32(&(g.state), StateFailed)
+	g.cleanUp()
+}
+
+func (g *Graph) cleanUp() {
+	g.cleanupMu.Lock()
+	defer g.cleanupMu.Unlock()
+
+	for _, f := range g.files {
+		_ = f.Close()
+	}
+	g.files = nil
+	for _, n := range g.nodes {
+		if n.pipeW != nil {
+			_ = n.pipeW.Close()
+			n.pipeW = nil
+		}
+	}
+}
+
+// release releases a reference to the stdin pipe of n, and closes it when it's no longer used,
+// so the command will get EOF.
+func (g *Graph) release(n *graphNode) {
+	g.cleanupMu.Lock()
+	defer g.cleanupMu.Unlock()
+
+	n.pipeRefs--
+	if n.pipeRefs == 0 && n.pipeW != nil {
+		_ = n.pipeW.Close()
+		n.pipeW = nil
+	}
+}
+
+func (g *Graph) setDeferredError(err error) *Graph {
+	if g.deferredError == nil && err != nil {
+		common.Warnf("Error detected: %v", err)
+		g.deferredError = err
+	}
+	return g
+}
+
+// setDeferredErrorQuietly is the same as setDeferredError, except it doesn't print a warning.
+func (g *Graph) setDeferredErrorQuietly(err error) *Graph {
+	if g.deferredError == nil && err != nil {
+		common.Debugf("Error detected: %v", err)
+		g.deferredError = err
+	}
+	return g
+}
+
+func (g *Graph) getNode(name string) *graphNode {
+	n, ok := g.byName[name]
+	if !ok {
+		panic(fmt.Sprintf("Command \"%s\" doesn't exist in the graph.", name))
+	}
+	return n
+}
+
+// Cmd returns the exec.Cmd of a given name.
+func (g *Graph) Cmd(name string) *exec.Cmd {
+	return g.getNode(name).cmd
+}
+
+// Command adds a new command with a given name to a Graph.
+func (g *Graph) Command(name string, command string, args ...string) *Graph {
+	g.ensureBuilding()
+	common.Debugf("Command: %s (%s)", command, name)
+
+	if _, ok := g.byName[name]; ok {
+		return g.setDeferredError(fmt.Errorf("duplicate command name \"%s\"", name))
+	}
+	cmd, err := newCmd(command, args...)
+	g.setDeferredErrorQuietly(err)
+
+	n := &graphNode{name: name, cmd: cmd}
+	g.nodes = append(g.nodes, n)
+	g.byName[name] = n
+	return g
+}
+
+// CommandWithEnv adds a new command with a given name to a Graph with environmental variables.
+func (g *Graph) CommandWithEnv(name string, env map[string]string, command string, args ...string) *Graph {
+	g.Command(name, command, args...)
+
+	e := make([]string, 0, len(env))
+	for k, v := range env {
+		e = append(e, k+"="+v)
+	}
+	g.getNode(name).cmd.Env = e
+	return g
+}
+
+// Pipe connects the stdout of the command from to the stdin of the command to.
+func (g *Graph) Pipe(from, to string) *Graph {
+	g.ensureBuilding()
+	f := g.getNode(from)
+	t := g.getNode(to)
+	for _, o := range f.outputs {
+		if o == t {
+			panic(fmt.Sprintf("Pipe() has already been called on \"%s\" -> \"%s\"", from, to))
+		}
+	}
+	f.outputs = append(f.outputs, t)
+	t.inputs = append(t.inputs, f)
+	return g
+}
+
+// SetStdin sets a reader to the stdin of a command, which must not have any input pipes.
+// Commands without input pipes read from os.Stdin by default.
+func (g *Graph) SetStdin(name string, reader io.Reader) *Graph {
+	g.ensureBuilding()
+	ensureNilAndSet(&g.getNode(name).stdin, reader, "Stdin already set to command %s", name)
+	return g
+}
+
+// SetStdout sets a writer to the stdout of a command, which must not have any output pipes.
+// Commands without output pipes write to os.Stdout by default.
+func (g *Graph) SetStdout(name string, writer io.Writer) *Graph {
+	g.ensureBuilding()
+	ensureNilAndSet(&g.getNode(name).stdout, writer, "Stdout already set to command %s", name)
+	return g
+}
+
+// SetStderr sets a writer to the stderr of a command.
+func (g *Graph) SetStderr(name string, writer io.Writer) *Graph {
+	g.ensureBuilding()
+	ensureNilAndSet(&g.getNode(name).stderr, writer, "Stderr already set to command %s", name)
+	return g
+}
+
+// AllowAnyStatus will allow a command to return any exit status code.
+func (g *Graph) AllowAnyStatus(name string, actualStatus *int) *Graph {
+	g.ensureBuilding()
+	g.getNode(name).validator = anyStatusValidator(actualStatus)
+	return g
+}
+
+// AllowStatus will allow a command to return any of specified exit status codes.
+// At least one status code must be provided.
+func (g *Graph) AllowStatus(name string, actualStatus *int, allowed ...int) *Graph {
+	g.ensureBuilding()
+	if len(allowed) == 0 {
+		panic("AllowStatus expects 1 or more allowed status codes.")
+	}
+	g.getNode(name).validator = statusValidator(actualStatus, allowed)
+	return g
+}
+
+func (g *Graph) checkCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[*graphNode]int)
+
+	var visit func(n *graphNode) error
+	visit = func(n *graphNode) error {
+		switch marks[n] {
+		case visiting:
+			return fmt.Errorf("cycle detected at command \"%s\"", n.name)
+		case visited:
+			return nil
+		}
+		marks[n] = visiting
+		for _, o := range n.outputs {
+			if err := visit(o); err != nil {
+				return err
+			}
+		}
+		marks[n] = visited
+		return nil
+	}
+	for _, n := range g.nodes {
+		if err := visit(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Graph) validateBeforeRun() error {
+	if g.deferredError != nil {
+		return g.deferredError
+	}
+	if len(g.nodes) == 0 {
+		panic("No command is set yet.")
+	}
+	for _, n := range g.nodes {
+		if n.stdin != nil && len(n.inputs) > 0 {
+			return fmt.Errorf("command \"%s\" has both stdin and input pipes", n.name)
+		}
+		if n.stdout != nil && len(n.outputs) > 0 {
+			return fmt.Errorf("command \"%s\" has both stdout and output pipes", n.name)
+		}
+	}
+	return g.checkCycles()
+}
+
+// setUpPipes creates the pipes between the commands.
+func (g *Graph) setUpPipes() error {
+	for _, n := range g.nodes {
+		if len(n.inputs) == 0 {
+			n.cmd.Stdin = n.stdin
+			if n.cmd.Stdin == nil {
+				n.cmd.Stdin = os.Stdin
+			}
+			continue
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("unable to create pipe for command \"%s\": %w", n.name, err)
+		}
+		n.cmd.Stdin = r
+		g.files = append(g.files, r)
+		n.pipeW = w
+
+		// The pipe is held until all the commands start, and by all the producers writing
+		// to it via a goroutine.
+		n.pipeRefs = 1
+		for _, in := range n.inputs {
+			if len(in.outputs) > 1 {
+				n.pipeRefs++
+			}
+		}
+	}
+	for _, n := range g.nodes {
+		switch len(n.outputs) {
+		case 0:
+			n.cmd.Stdout = n.stdout
+			if n.cmd.Stdout == nil {
+				n.cmd.Stdout = os.Stdout
+			}
+		case 1:
+			// The child process writes to the pipe directly.
+			n.cmd.Stdout = n.outputs[0].pipeW
+		default:
+			writers := make([]io.Writer, len(n.outputs))
+			for i, o := range n.outputs {
+				writers[i] = o.pipeW
+			}
+			n.cmd.Stdout = &fanOutWriter{writers: writers}
+		}
+		n.cmd.Stderr = n.stderr
+		if n.cmd.Stderr == nil {
+			n.cmd.Stderr = os.Stderr
+		}
+		if n.validator == nil {
+			n.validator = standardValidator
+		}
+	}
+	return nil
+}
+
+// fanOutWriter writes to all the writers, like io.MultiWriter, except when a writer fails (e.g.
+// the consumer has exited), it's dropped and the rest still get the data. It fails only when
+// all the writers have failed.
+type fanOutWriter struct {
+	writers []io.Writer
+}
+
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	var lastErr error
+	alive := w.writers[:0]
+	for _, wr := range w.writers {
+		if _, err := wr.Write(p); err != nil {
+			lastErr = err
+			continue
+		}
+		alive = append(alive, wr)
+	}
+	w.writers = alive
+	if len(alive) == 0 {
+		return 0, lastErr
+	}
+	return len(p), nil
+}
+
+// Run starts all the commands in a Graph.
+func (g *Graph) Run() (*GraphWaiter, error) {
+	g.moveToRunning()
+
+	err := g.validateBeforeRun()
+	if err == nil {
+		err = g.setUpPipes()
+	}
+	if err != nil {
+		g.moveToFailed()
+		return nil, err
+	}
+
+	for i, n := range g.nodes {
+		err := n.cmd.Start()
+		if err != nil {
+			g.moveToFailed()
+			killAndWait(g.nodes[:i])
+			return nil, fmt.Errorf("unable to execute command \"%s\" (command \"%s\"): %s", n.cmd.Path, n.name, err.Error())
+		}
+	}
+
+	// Now the children have their own copies of the pipes.
+	g.cleanupMu.Lock()
+	for _, f := range g.files {
+		_ = f.Close()
+	}
+	g.files = nil
+	g.cleanupMu.Unlock()
+
+	for _, n := range g.nodes {
+		if len(n.inputs) > 0 {
+			g.release(n)
+		}
+	}
+
+	return &GraphWaiter{Graph: g}, nil
+}
+
+// killAndWait kills commands that have already started and waits for them, so they won't be
+// left as zombies.
+func killAndWait(nodes []*graphNode) {
+	for _, n := range nodes {
+		_ = n.cmd.Process.Kill()
+	}
+	for _, n := range nodes {
+		_ = n.cmd.Wait()
+	}
+}
+
+// MustRun starts all the commands in a Graph.
+func (g *Graph) MustRun() *GraphWaiter {
+	gw, err := g.Run()
+	common.CheckPanic(err, "Unable to execute command(s)")
+	return gw
+}
+
+// MustRunAndWait starts all the commands in a Graph and wait().
+func (g *Graph) MustRunAndWait() *GraphResult {
+	return g.MustRun().MustWait()
+}
+
+// Wait wait() on all commands in a Graph. If any of the commands fail, it returns a *CommandError
+// for the first failed command in the order they were added.
+func (gw *GraphWaiter) Wait() (*GraphResult, error) {
+	g := gw.Graph
+	g.moveToWaiting()
+
+	errs := make([]error, len(g.nodes))
+	var wg sync.WaitGroup
+	for i, n := range g.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := n.cmd.Wait()
+			errs[i] = n.validator(n.cmd, err)
+
+			if len(n.outputs) > 1 {
+				for _, o := range n.outputs {
+					g.release(o)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			g.moveToFailed()
+			return nil, &CommandError{Index: i, Name: g.nodes[i].name, Cmd: g.nodes[i].cmd, Err: err}
+		}
+	}
+	g.moveToSucceeded()
+
+	return &GraphResult{Graph: g}, nil
+}
+
+// MustWait wait() on all commands in a Graph.
+func (gw *GraphWaiter) MustWait() *GraphResult {
+	gr, err := gw.Wait()
+	common.CheckPanice(err)
+	return gr
+}
@@ -0,0 +1,129 @@
+package cmdchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraph(t *testing.T) {
+	{
+		// Fan-out and fan-in.
+		var out bytes.Buffer
+		NewGraph().
+			Command("src", "printf", "a1\\nb1\\na2\\nb2\\n").
+			Command("a", "grep", "a").
+			Command("b", "grep", "b").
+			Command("sort", "sort").
+			Pipe("src", "a").
+			Pipe("src", "b").
+			Pipe("a", "sort").
+			Pipe("b", "sort").
+			SetStdout("sort", &out).
+			MustRunAndWait()
+		assert.Equal(t, "a1\na2\nb1\nb2\n", out.String())
+	}
+
+	{
+		// Fan-in from independent producers.
+		var out bytes.Buffer
+		g := NewGraph().
+			Command("p1", "echo", "x").
+			Command("p2", "echo", "y").
+			Command("wc", "wc", "-l").
+			Pipe("p1", "wc").
+			Pipe("p2", "wc").
+			SetStdout("wc", &out)
+		g.SetStdin("p1", bytes.NewReader(nil))
+		g.MustRunAndWait()
+		assert.Equal(t, "2\n", out.String())
+	}
+
+	{
+		var status int
+		var out bytes.Buffer
+		NewGraph().
+			Command("src", "bash", "-c", "echo ok; exit 3").
+			AllowStatus("src", &status, 3).
+			Command("cat", "cat").
+			Pipe("src", "cat").
+			SetStdout("cat", &out).
+			MustRunAndWait()
+		assert.Equal(t, "ok\n", out.String())
+		assert.Equal(t, 3, status)
+	}
+
+	{
+		var out1, out2 bytes.Buffer
+		_, err := NewGraph().
+			Command("src", "echo", "ok").
+			Command("bad", "bash", "-c", "cat; exit 5").
+			Command("good", "cat").
+			Pipe("src", "bad").
+			Pipe("src", "good").
+			SetStdout("bad", &out1).
+			SetStdout("good", &out2).
+			MustRun().Wait()
+		var ce *CommandError
+		if assert.True(t, errors.As(err, &ce)) {
+			assert.EqualError(t, err, "failed to wait on command "+ce.Cmd.Path+" (command \"bad\"): exit status 5")
+			assert.Equal(t, "bad", ce.Name)
+			assert.Equal(t, 1, ce.Index)
+			assert.Equal(t, 5, ExitStatusOf(err))
+		}
+	}
+
+	{
+		// A consumer exiting early doesn't truncate the input to the other consumers.
+		var head, wc bytes.Buffer
+		NewGraph().
+			Command("src", "seq", "200000").
+			Command("head", "head", "-1").
+			Command("wc", "wc", "-l").
+			Pipe("src", "head").
+			Pipe("src", "wc").
+			SetStdout("head", &head).
+			SetStdout("wc", &wc).
+			MustRunAndWait()
+		assert.Equal(t, "1\n", head.String())
+		assert.Equal(t, "200000\n", wc.String())
+	}
+
+	{
+		_, err := NewGraph().
+			Command("a", "cat").
+			Command("b", "cat").
+			Pipe("a", "b").
+			Pipe("b", "a").
+			Run()
+		assert.ErrorContains(t, err, "cycle detected")
+	}
+
+	{
+		_, err := NewGraph().Command("a", "cat").Command("a", "cat").Run()
+		assert.ErrorContains(t, err, "duplicate command name")
+	}
+
+	{
+		assert.PanicsWithValue(t, "Command \"x\" doesn't exist in the graph.", func() {
+			NewGraph().Command("a", "cat").Pipe("a", "x")
+		}, "Expected panic")
+	}
+}
+
+func TestGraphStartFailure(t *testing.T) {
+	g := NewGraph().
+		Command("sleep", "sleep", "10").
+		Command("bad", "./no-such-command").
+		Pipe("sleep", "bad")
+	_, err := g.Run()
+	assert.ErrorContains(t, err, "unable to execute command")
+
+	// The command that has already started is killed and waited on.
+	ps := g.nodes[0].cmd.ProcessState
+	if assert.NotNil(t, ps) {
+		assert.False(t, ps.Exited())
+	}
+}
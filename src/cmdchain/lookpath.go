@@ -0,0 +1,168 @@
+package cmdchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/omakoto/go-common/src/utils"
+)
+
+const maxSuggestions = 3
+
+// CommandNotFoundError is returned when a command can't be found in PATH.
+type CommandNotFoundError struct {
+	Name        string
+	Suggestions []string
+	Err         error
+}
+
+func (e *CommandNotFoundError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "command \"%s\" not found in PATH", e.Name)
+	if len(e.Suggestions) > 0 {
+		sb.WriteString(" (did you mean ")
+		for i, s := range e.Suggestions {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "\"%s\"", s)
+		}
+		sb.WriteString("?)")
+	}
+	return sb.String()
+}
+
+func (e *CommandNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	lookPathMu sync.Mutex
+	// lookPathCache caches successful lookups only, so commands installed later will be found.
+	lookPathCache = make(map[string]string)
+	pinnedPaths   = make(map[string]string)
+)
+
+// PinCommand makes commands named name always execute path, bypassing the PATH lookup.
+// path must be absolute.
+func PinCommand(name, path string) {
+	if !filepath.IsAbs(path) {
+		panic(fmt.Sprintf("PinCommand expects an absolute path, but got \"%s\"", path))
+	}
+	lookPathMu.Lock()
+	defer lookPathMu.Unlock()
+	pinnedPaths[name] = path
+}
+
+// UnpinCommand removes a path pinned with PinCommand.
+func UnpinCommand(name string) {
+	lookPathMu.Lock()
+	defer lookPathMu.Unlock()
+	delete(pinnedPaths, name)
+}
+
+// ClearLookPathCache clears the cached results of PATH lookups, which is needed
+// when executables are moved or removed while the process is running. (Failed lookups
+// aren't cached.)
+func ClearLookPathCache() {
+	lookPathMu.Lock()
+	defer lookPathMu.Unlock()
+	lookPathCache = make(map[string]string)
+}
+
+// lookPath resolves a command name to a full path, using pinned paths and the cache.
+func lookPath(name string) (string, error) {
+	if strings.ContainsRune(name, os.PathSeparator) {
+		// Let exec.Command handle paths.
+		return name, nil
+	}
+
+	lookPathMu.Lock()
+	defer lookPathMu.Unlock()
+
+	if p, ok := pinnedPaths[name]; ok {
+		return p, nil
+	}
+
+	path := os.Getenv("PATH")
+	key := path + "\x00" + name
+	if p, ok := lookPathCache[key]; ok {
+		return p, nil
+	}
+
+	resolved, err := exec.LookPath(name)
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			err = &CommandNotFoundError{Name: name, Suggestions: findSimilarCommands(name, path), Err: err}
+		}
+		return "", err
+	}
+	lookPathCache[key] = resolved
+	return resolved, nil
+}
+
+// findSimilarCommands returns executables in path whose names are close to name.
+func findSimilarCommands(name, path string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	maxDistance := min(2, len(name)/2)
+
+	seen := make(map[string]bool)
+	var candidates []candidate
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			n := e.Name()
+			if seen[n] || e.IsDir() {
+				continue
+			}
+			d := utils.EditDistance(name, n)
+			if d > maxDistance {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			seen[n] = true
+			candidates = append(candidates, candidate{n, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var ret []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		ret = append(ret, candidates[i].name)
+	}
+	return ret
+}
+
+// newCmd is the same as exec.Command, except it uses lookPath to resolve the command.
+func newCmd(name string, args ...string) (*exec.Cmd, error) {
+	resolved, err := lookPath(name)
+	if err != nil {
+		return exec.Command(name, args...), err
+	}
+	cmd := exec.Command(resolved, args...)
+	cmd.Args[0] = name
+	return cmd, nil
+}
@@ -0,0 +1,182 @@
+package cmdchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream identifies which output stream a TranscriptEntry came from.
+type Stream int
+
+const (
+	StreamStdout Stream = iota
+	StreamStderr
+)
+
+func (s Stream) String() string {
+	switch s {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	}
+	return fmt.Sprintf("stream(%d)", int(s))
+}
+
+func (s Stream) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// TranscriptEntry is a single line written by a command to either stdout or stderr.
+type TranscriptEntry struct {
+	Stream Stream `json:"stream"`
+
+	// Time is the wall clock time the line was received.
+	Time time.Time `json:"time"`
+
+	// Elapsed is the time since the transcript was created, using the monotonic clock.
+	Elapsed time.Duration `json:"elapsed_ns"`
+
+	// Data is the line content, including the trailing newline, if any.
+	Data []byte `json:"-"`
+}
+
+// Text returns the line content without the trailing newline.
+func (e *TranscriptEntry) Text() string {
+	return strings.TrimSuffix(string(e.Data), "\n")
+}
+
+func (e TranscriptEntry) MarshalJSON() ([]byte, error) {
+	type entry TranscriptEntry
+	return json.Marshal(struct {
+		entry
+		Text string `json:"text"`
+	}{entry(e), e.Text()})
+}
+
+// Transcript records stdout and stderr of commands in a single ordered list of lines,
+// tagged with the stream and timestamp. Use it with CommandChain.Capture.
+type Transcript struct {
+	mu       sync.Mutex
+	start    time.Time
+	entries  []TranscriptEntry
+	pending  [2][]byte
+	finished bool
+}
+
+// NewTranscript creates a new Transcript. Elapsed times of the entries are relative to
+// when it's created.
+func NewTranscript() *Transcript {
+	return &Transcript{start: time.Now()}
+}
+
+// addLocked appends a line to the transcript. t.mu must be held.
+func (t *Transcript) addLocked(stream Stream, data []byte) {
+	now := time.Now()
+	t.entries = append(t.entries, TranscriptEntry{
+		Stream:  stream,
+		Time:    now,
+		Elapsed: now.Sub(t.start),
+		Data:    data,
+	})
+}
+
+func (t *Transcript) write(stream Stream, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	buf := append(t.pending[stream], data...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := make([]byte, i+1)
+		copy(line, buf[:i+1])
+		t.addLocked(stream, line)
+		buf = buf[i+1:]
+	}
+	t.pending[stream] = append([]byte(nil), buf...)
+}
+
+// finish flushes incomplete lines and makes the transcript readable.
+func (t *Transcript) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.finished {
+		return
+	}
+	for s := range t.pending {
+		if len(t.pending[s]) > 0 {
+			t.addLocked(Stream(s), t.pending[s])
+			t.pending[s] = nil
+		}
+	}
+	t.finished = true
+}
+
+// Entries returns all the recorded lines. It panics if the CommandChain hasn't been waited yet.
+func (t *Transcript) Entries() []TranscriptEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.finished {
+		panic("CommandChain hasn't been waited yet.")
+	}
+	return t.entries
+}
+
+// WriteText writes the transcript as plain text, one line per entry, in the form of
+// "[elapsed] stream: text".
+func (t *Transcript) WriteText(wr io.Writer) error {
+	for _, e := range t.Entries() {
+		_, err := fmt.Fprintf(wr, "[%10.6f] %s: %s\n", e.Elapsed.Seconds(), e.Stream, e.Text())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns the transcript as plain text. See WriteText.
+func (t *Transcript) String() string {
+	var buf strings.Builder
+	_ = t.WriteText(&buf)
+	return buf.String()
+}
+
+// JSON returns the transcript as a JSON array.
+func (t *Transcript) JSON() ([]byte, error) {
+	entries := t.Entries()
+	if entries == nil {
+		entries = []TranscriptEntry{}
+	}
+	return json.Marshal(entries)
+}
+
+type transcriptWriter struct {
+	t      *Transcript
+	stream Stream
+}
+
+func (w *transcriptWriter) Write(data []byte) (int, error) {
+	w.t.write(w.stream, data)
+	return len(data), nil
+}
+
+// Capture records both stdout and stderr of the last command into t. t becomes readable
+// after the CommandChain is waited, regardless of whether the commands succeeded or not.
+// The same Transcript may be shared by multiple commands.
+func (c *CommandChain) Capture(t *Transcript) *CommandChain {
+	c.ensureBuilding()
+	c.SetStdout(&transcriptWriter{t, StreamStdout})
+	c.SetStderr(&transcriptWriter{t, StreamStderr})
+	c.transcripts = append(c.transcripts, t)
+	return c
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mattn/go-isatty"
+)
+
+// ColorMode decides whether the Debug / Warn / Fatal functions use colors.
+type ColorMode int32
+
+const (
+	// ColorAuto uses colors when the log output is a terminal and NO_COLOR isn't set.
+	ColorAuto ColorMode = iota
+	ColorAlways
+	ColorNever
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorDim    = "\x1b[2m"
+)
+
+var colorMode atomic.Int32
+
+func init() {
+	switch strings.ToLower(GetBinEnv("COLOR")) {
+	case "always", "1", "yes", "on":
+		SetColorMode(ColorAlways)
+	case "never", "0", "no", "off":
+		SetColorMode(ColorNever)
+	}
+}
+
+// SetColorMode sets whether to use colors. <BIN>_COLOR can also be set to "always" or "never".
+func SetColorMode(mode ColorMode) {
+	colorMode.Store(int32(mode))
+}
+
+func colorEnabled() bool {
+	switch ColorMode(colorMode.Load()) {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return logOutputIsTerminal()
+}
+
+// logOutputIsTerminal returns whether the current log output is a terminal.
+func logOutputIsTerminal() bool {
+	logOutputMu.Lock()
+	defer logOutputMu.Unlock()
+
+	f := os.Stderr
+	if logOutput != nil {
+		var ok bool
+		if f, ok = logOutput.(*os.File); !ok {
+			return false
+		}
+	}
+	return isatty.IsTerminal(f.Fd())
+}
+
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= LevelFatal:
+		return colorRed
+	case level >= LevelWarn:
+		return colorYellow
+	case level < LevelVerbose:
+		return colorDim
+	}
+	return ""
+}
@@ -7,8 +7,6 @@ import (
 	"runtime"
 	"runtime/debug"
 	"strings"
-
-	"github.com/davecgh/go-spew/spew"
 )
 
 var (
@@ -22,10 +20,7 @@ var (
 )
 
 func init() {
-	if GetBinEnv("DEBUG") == "1" || os.Getenv("DEBUG") == "1" {
-		DebugEnabled = true
-		VerboseEnabled = true
-	}
+	initLogging()
 }
 
 // MustGetExecutable returns the filename of the self binary.
@@ -43,8 +38,21 @@ func MustGetBinName() string {
 	return cachedBinName
 }
 
+// SetBinName overrides the binary name returned by MustGetBinName, which is also used by
+// GetBinEnv, and returns the previous name. This is mainly for tests.
+func SetBinName(name string) string {
+	prev := MustGetBinName()
+	cachedBinName = name
+	return prev
+}
+
+// BinEnvName returns the name of the environmental variable GetBinEnv reads for a given suffix.
+func BinEnvName(suffix string) string {
+	return fmt.Sprintf("%s_%s", strings.Replace(strings.ToUpper(MustGetBinName()), "-", "_", -1), suffix)
+}
+
 func GetBinEnv(suffix string) string {
-	return os.Getenv(fmt.Sprintf("%s_%s", strings.Replace(strings.ToUpper(MustGetBinName()), "-", "_", -1), suffix))
+	return os.Getenv(BinEnvName(suffix))
 }
 
 func MustGetenv(name string) string {
@@ -65,23 +73,19 @@ func MustGetHome() string {
 
 func maybePrintStackTrack() {
 	if GetBinEnv("PRINT_STACK") == "1" {
-		debug.PrintStack()
+		_, _ = logWriter{}.Write(debug.Stack())
 	}
 }
 
-func maybeLf(msg string) string {
-	l := len(msg)
-	if l > 0 && msg[l-1] == '\n' {
-		return ""
+func printFatal(message string) {
+	if !Quiet {
+		logMessage(LevelFatal, message)
+		maybePrintStackTrack()
 	}
-	return "\n"
 }
 
 func Fatal(message string) {
-	if !Quiet {
-		Warn(message)
-		maybePrintStackTrack()
-	}
+	printFatal(message)
 	ExitFailure()
 }
 
@@ -91,7 +95,7 @@ func Fatalf(format string, args ...interface{}) {
 
 func Warn(message string) {
 	if !Quiet {
-		fmt.Fprint(os.Stderr, MustGetBinName(), ": ", message, maybeLf(message))
+		logMessage(LevelWarn, message)
 	}
 }
 
@@ -167,39 +171,46 @@ func Debug(message string) {
 	if !DebugEnabled {
 		return
 	}
-	fmt.Fprint(os.Stderr, message, maybeLf(message))
+	logDebugMessage(LevelDebug, callerPC(0), message)
 }
 
 func Debugf(format string, args ...interface{}) {
 	if !DebugEnabled {
 		return
 	}
-	Debug(fmt.Sprintf(format, args...))
+	logDebugMessage(LevelDebug, callerPC(0), fmt.Sprintf(format, args...))
 }
 
 func Verbose(message string) {
 	if !VerboseEnabled && !DebugEnabled {
 		return
 	}
-	fmt.Fprint(os.Stderr, message, maybeLf(message))
+	logDebugMessage(LevelVerbose, callerPC(0), message)
 }
 
 func Verbosef(format string, args ...interface{}) {
 	if !VerboseEnabled && !DebugEnabled {
 		return
 	}
-	Verbose(fmt.Sprintf(format, args...))
+	logDebugMessage(LevelVerbose, callerPC(0), fmt.Sprintf(format, args...))
 }
 
+// Dump prints object with DefaultDumper as a debug message, if DebugEnabled is true.
 func Dump(prefix string, object interface{}) {
 	if !DebugEnabled {
 		return
 	}
-	Debugf("%s%s", prefix, spew.Sdump(object))
+	logDebugMessage(LevelDebug, callerPC(0), prefix+DefaultDumper.Sdump(object))
 }
 
 func GetSourceInfo() (string, int) {
-	_, fileName, fileLine, ok := runtime.Caller(1)
+	return getSourceInfo(2)
+}
+
+// getSourceInfo returns the filename and the line number of a caller, where skip is the same as
+// runtime.Caller.
+func getSourceInfo(skip int) (string, int) {
+	_, fileName, fileLine, ok := runtime.Caller(skip)
 	if ok {
 		return fileName, fileLine
 	}
@@ -0,0 +1,153 @@
+// Package commontest provides helpers to test code that uses the common package, such as
+// code calling common.Fatal or common.ExitFailure.
+package commontest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/omakoto/go-common/src/common"
+)
+
+// Options are options for RunWithOptions.
+type Options struct {
+	// BinName overrides the binary name returned by common.MustGetBinName, if not empty.
+	BinName string
+
+	// Env sets environmental variables read by common.GetBinEnv. Keys are the suffixes passed to
+	// GetBinEnv, such as "CACHE_SIZE". Variables read at initialization, such as <BIN>_DEBUG, aren't
+	// affected; use Debug and Verbose instead.
+	Env map[string]string
+
+	// Debug enables common.Debug output.
+	Debug bool
+
+	// Verbose enables common.Verbose output.
+	Verbose bool
+}
+
+// Result is the result of Run and its variants.
+type Result struct {
+	// Status is the exit status, which RunAndExit would have exited with.
+	Status int
+
+	// Stdout is what was written to os.Stdout.
+	Stdout string
+
+	// Stderr is what was written to os.Stderr, including messages from common.Warn, Debug,
+	// Verbose and Fatal.
+	Stderr string
+}
+
+// Run runs f in the same way as common.RunAndExit, except it returns the exit status and the output
+// instead of exiting.
+func Run(t testing.TB, f func() int) *Result {
+	return RunWithOptions(t, Options{}, f)
+}
+
+// RunErr runs f in the same way as common.RunAndExitErr, except it returns the exit status and
+// the output instead of exiting.
+func RunErr(t testing.TB, f func() error) *Result {
+	return RunErrWithOptions(t, Options{}, f)
+}
+
+// RunErrWithOptions is the same as RunErr with options.
+func RunErrWithOptions(t testing.TB, options Options, f func() error) *Result {
+	t.Helper()
+	return run(t, options, func() int {
+		return common.RunAndReturnErr(f)
+	})
+}
+
+// RunWithOptions is the same as Run with options.
+//
+// Because it changes process-wide states such as os.Stdout and os.Stderr, it must not be used
+// in parallel tests. A panic in f isn't recovered, so it fails the test as usual, rather than
+// writing a crash report.
+//
+// Messages are captured only when they're written to os.Stderr, which is the default; they're
+// not captured when the log output is changed with common.SetLogOutput or similar.
+func RunWithOptions(t testing.TB, options Options, f func() int) *Result {
+	t.Helper()
+	return run(t, options, func() int {
+		return common.RunAndReturn(f)
+	})
+}
+
+// run sets up the environment with options, and calls runner, which should call f with
+// common.RunAndReturn or common.RunAndReturnErr.
+func run(t testing.TB, options Options, runner func() int) *Result {
+	t.Helper()
+
+	if options.BinName != "" {
+		prev := common.SetBinName(options.BinName)
+		t.Cleanup(func() { common.SetBinName(prev) })
+	}
+
+	if _, ok := options.Env["CRASH_REPORT"]; !ok {
+		t.Setenv(common.BinEnvName("CRASH_REPORT"), "0")
+	}
+	for k, v := range options.Env {
+		t.Setenv(common.BinEnvName(k), v)
+	}
+
+	if options.Debug || options.Verbose {
+		prev := common.GetLogLevel()
+		t.Cleanup(func() { common.SetLogLevel(prev) })
+		if options.Debug {
+			common.SetLogLevel(common.LevelDebug)
+		} else {
+			common.SetLogLevel(common.LevelVerbose)
+		}
+	}
+
+	stdout := newCapture(t, &os.Stdout)
+	stderr := newCapture(t, &os.Stderr)
+
+	status := func() int {
+		defer stdout.restore()
+		defer stderr.restore()
+		return runner()
+	}()
+
+	return &Result{
+		Status: status,
+		Stdout: stdout.read(),
+		Stderr: stderr.read(),
+	}
+}
+
+// capture replaces an *os.File variable with a temporary file.
+type capture struct {
+	t    testing.TB
+	v    **os.File
+	orig *os.File
+	temp *os.File
+}
+
+func newCapture(t testing.TB, v **os.File) *capture {
+	t.Helper()
+
+	temp, err := os.CreateTemp(t.TempDir(), "output*.txt")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	c := &capture{t: t, v: v, orig: *v, temp: temp}
+	*v = temp
+	return c
+}
+
+func (c *capture) restore() {
+	*c.v = c.orig
+}
+
+func (c *capture) read() string {
+	c.t.Helper()
+	defer c.temp.Close()
+
+	data, err := os.ReadFile(c.temp.Name())
+	if err != nil {
+		c.t.Fatalf("Unable to read captured output: %s", err)
+	}
+	return string(data)
+}
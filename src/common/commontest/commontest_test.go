@@ -0,0 +1,89 @@
+package commontest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/omakoto/go-common/src/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	r := Run(t, func() int {
+		fmt.Println("out")
+		common.Warn("warning")
+		common.Debug("debug")
+		return 3
+	})
+	bin := common.MustGetBinName()
+	assert.Equal(t, 3, r.Status)
+	assert.Equal(t, "out\n", r.Stdout)
+	assert.Equal(t, bin+": warning\n", r.Stderr)
+}
+
+func TestRunFatal(t *testing.T) {
+	bin := common.MustGetBinName()
+	t.Run("fatal", func(t *testing.T) {
+		r := RunWithOptions(t, Options{BinName: "mytool"}, func() int {
+			common.Fatal("broken")
+			return 0
+		})
+		assert.Equal(t, 1, r.Status)
+		assert.Equal(t, "mytool: broken\n", r.Stderr)
+	})
+	assert.Equal(t, bin, common.MustGetBinName())
+}
+
+func TestRunExit(t *testing.T) {
+	r := Run(t, func() int {
+		common.ExitWithStatus(5)
+		return 0
+	})
+	assert.Equal(t, 5, r.Status)
+	assert.Equal(t, "", r.Stderr)
+}
+
+func TestRunOptions(t *testing.T) {
+	debug := common.DebugEnabled
+	t.Run("options", func(t *testing.T) {
+		r := RunWithOptions(t, Options{
+			BinName: "my-tool",
+			Env:     map[string]string{"NAME": "abc"},
+			Debug:   true,
+		}, func() int {
+			common.Debugf("name=%s", common.GetBinEnv("NAME"))
+			return 0
+		})
+		assert.Equal(t, 0, r.Status)
+		assert.Equal(t, "name=abc\n", r.Stderr)
+	})
+	assert.Equal(t, debug, common.DebugEnabled)
+	assert.Equal(t, "", os.Getenv("MY_TOOL_NAME"))
+}
+
+func TestRunErr(t *testing.T) {
+	r := RunErrWithOptions(t, Options{BinName: "mytool"}, func() error {
+		return common.Usagef("bad flag")
+	})
+	assert.Equal(t, common.ExitStatusUsage, r.Status)
+	assert.Equal(t, "mytool: bad flag\n", r.Stderr)
+
+	r = RunErr(t, func() error {
+		return errors.New("x")
+	})
+	assert.Equal(t, 1, r.Status)
+}
+
+func TestRunErrProfile(t *testing.T) {
+	// Profiling is started only once.
+	file := filepath.Join(t.TempDir(), "cpu.prof")
+	r := RunErrWithOptions(t, Options{Env: map[string]string{"CPUPROFILE": file}}, func() error {
+		return nil
+	})
+	assert.Equal(t, 0, r.Status)
+	assert.Equal(t, "", r.Stderr)
+	assert.FileExists(t, file)
+}
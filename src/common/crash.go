@@ -0,0 +1,149 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExitStatusCrash is the exit status used after writing a crash report, which is the same as
+// the status the Go runtime uses for unrecovered panics.
+const ExitStatusCrash = 2
+
+// crashReportEnvs are environmental variables included in crash reports, in addition to
+// the ones starting with the binary name.
+var crashReportEnvs = []string{"HOME", "LANG", "LC_ALL", "PATH", "PWD", "SHELL", "TERM", "USER"}
+
+// redactedEnv returns whether to hide the value of an environmental variable in crash reports,
+// which is when the name contains any of DefaultDumper.RedactFields, e.g. "<BIN>_API_TOKEN".
+func redactedEnv(name string) bool {
+	name = strings.ToLower(name)
+	for _, f := range DefaultDumper.RedactFields {
+		if strings.Contains(name, strings.ToLower(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+func allStacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+func buildCrashReport(r any, now time.Time, stacks []byte) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Crash report for %s\n", MustGetBinName())
+	fmt.Fprintf(&sb, "Time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "PID: %d\n", os.Getpid())
+	fmt.Fprintf(&sb, "Version: %s\n", GetBuildInfo())
+	fmt.Fprintf(&sb, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&sb, "\nPanic: %v\n", r)
+
+	sb.WriteString("\nArgs:\n")
+	for i, a := range os.Args {
+		fmt.Fprintf(&sb, "  [%d] %q\n", i, a)
+	}
+
+	sb.WriteString("\nEnvironment:\n")
+	prefix := BinEnvName("")
+	var envs []string
+	for _, e := range os.Environ() {
+		name, _, _ := strings.Cut(e, "=")
+		if strings.HasPrefix(name, prefix) {
+			envs = append(envs, e)
+		}
+	}
+	for _, name := range crashReportEnvs {
+		if v, ok := os.LookupEnv(name); ok {
+			envs = append(envs, name+"="+v)
+		}
+	}
+	sort.Strings(envs)
+	for _, e := range envs {
+		if name, _, _ := strings.Cut(e, "="); redactedEnv(name) {
+			e = name + "=" + dumpRedacted
+		}
+		fmt.Fprintf(&sb, "  %s\n", e)
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		sb.WriteString("\nBuild info:\n")
+		for _, line := range strings.Split(strings.TrimRight(bi.String(), "\n"), "\n") {
+			fmt.Fprintf(&sb, "  %s\n", line)
+		}
+	}
+
+	sb.WriteString("\nGoroutines:\n")
+	sb.Write(stacks)
+	return sb.String()
+}
+
+// getCrashReportDir returns (and creates) $XDG_STATE_HOME/BINNAME/crash. Unlike GetStateDir, it
+// never calls Fatal, which would lose the original panic.
+func getCrashReportDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" || !filepath.IsAbs(base) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return ensureDir(filepath.Join(base, MustGetBinName(), "crash"))
+}
+
+// oneLine joins the lines of s with spaces.
+func oneLine(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// writeCrashReport writes a crash report for a panic value r, and returns the filename.
+func writeCrashReport(r any) (string, error) {
+	stacks := allStacks()
+	now := time.Now()
+
+	dir, err := getCrashReportDir()
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(dir, fmt.Sprintf("crash-%s-%d.txt", now.Format("20060102-150405"), os.Getpid()))
+	err = os.WriteFile(filename, []byte(buildCrashReport(r, now, stacks)), 0600)
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// handleCrash handles an unrecovered panic in RunAndExit, writing a crash report and returning the
+// exit status. If <BIN>_CRASH_REPORT is "0", or the report can't be written, it panics again with r.
+func handleCrash(r any) int {
+	if GetBinEnv("CRASH_REPORT") == "0" {
+		panic(r)
+	}
+	filename, err := writeCrashReport(r)
+	if err != nil {
+		Warnf("Unable to write crash report: %s", err)
+		panic(r)
+	}
+	logMessage(LevelFatal, fmt.Sprintf("panic: %s (crash report: %s)", oneLine(fmt.Sprint(r)), filename))
+	return ExitStatusCrash
+}
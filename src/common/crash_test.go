@@ -0,0 +1,82 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrashReport(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+	t.Setenv(BinEnvName("TEST_VAR"), "test-value")
+	t.Setenv(BinEnvName("API_TOKEN"), "token-value")
+	t.Setenv(BinEnvName("DB_PASSWORD"), "password-value")
+
+	var status int
+	out := captureStderr(t, func() {
+		status = runWithRescue(func() int {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, ExitStatusCrash, status)
+	assert.True(t, strings.HasPrefix(out, MustGetBinName()+": panic: boom (crash report: "+dir), out)
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+
+	files, err := filepath.Glob(filepath.Join(dir, MustGetBinName(), "crash", "crash-*.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	data, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	report := string(data)
+	assert.Contains(t, report, "Panic: boom\n")
+	assert.Contains(t, report, "  "+BinEnvName("TEST_VAR")+"=test-value\n")
+	assert.Contains(t, report, "  "+BinEnvName("API_TOKEN")+"=<redacted>\n")
+	assert.Contains(t, report, "  "+BinEnvName("DB_PASSWORD")+"=<redacted>\n")
+	assert.NotContains(t, report, "token-value")
+	assert.NotContains(t, report, "password-value")
+	assert.Contains(t, report, "Args:\n  [0] ")
+	assert.Contains(t, report, "Goroutines:\ngoroutine ")
+	assert.Contains(t, report, "TestCrashReport")
+}
+
+func TestCrashReportDisabled(t *testing.T) {
+	t.Setenv(BinEnvName("CRASH_REPORT"), "0")
+	assert.PanicsWithValue(t, "boom", func() {
+		runWithRescue(func() int {
+			panic("boom")
+		})
+	})
+}
+
+func TestCrashReportMultiLine(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+
+	out := captureStderr(t, func() {
+		runWithRescue(func() int {
+			panic("line 1\n  line 2\n")
+		})
+	})
+	assert.True(t, strings.HasPrefix(out, MustGetBinName()+": panic: line 1 line 2 (crash report: "), out)
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+}
+
+func TestCrashReportNoHome(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "")
+
+	// The original panic is kept, rather than Fatal from the home directory lookup.
+	out := captureStderr(t, func() {
+		assert.PanicsWithValue(t, "boom", func() {
+			runWithRescue(func() int {
+				panic("boom")
+			})
+		})
+	})
+	assert.Contains(t, out, "Unable to write crash report")
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"bytes"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	logCaller    atomic.Bool
+	logGoroutine atomic.Bool
+
+	debugFilterMu sync.RWMutex
+	debugFilter   []string
+
+	// debugFilterCache caches whether to show debug messages for each PC.
+	debugFilterCache sync.Map
+)
+
+// initDebugInfo sets up the debug info from the environmental variables, and returns whether
+// debug output should be enabled. It's called by initLogging.
+//   - <BIN>_LOG_CALLER, <BIN>_LOG_GOROUTINE: see SetLogCallerInfo.
+//   - <BIN>_DEBUG_FILTER: enables debug output with a filter. See SetDebugFilter.
+func initDebugInfo() bool {
+	SetLogCallerInfo(GetBinEnv("LOG_CALLER") == "1", GetBinEnv("LOG_GOROUTINE") == "1")
+
+	if f := GetBinEnv("DEBUG_FILTER"); f != "" {
+		SetDebugFilter(f)
+		return true
+	}
+	return false
+}
+
+// SetLogCallerInfo sets whether to add the caller file:line ("caller") and the goroutine ID
+// ("goroutine") to debug and verbose messages. They can also be enabled by setting
+// <BIN>_LOG_CALLER and <BIN>_LOG_GOROUTINE to "1".
+func SetLogCallerInfo(caller, goroutine bool) {
+	logCaller.Store(caller)
+	logGoroutine.Store(goroutine)
+}
+
+// SetDebugFilter limits debug messages to the ones from the source files matching the filter,
+// which is comma separated glob patterns. Each pattern is matched against the package path
+// (e.g. "github.com/omakoto/go-common/src/*"), and the trailing components of the filename
+// (e.g. "cmdchain/*.go", "commands.go"). An empty filter shows all the debug messages.
+// Setting <BIN>_DEBUG_FILTER also enables debug output with the filter.
+func SetDebugFilter(filter string) {
+	var patterns []string
+	for _, p := range strings.Split(filter, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	debugFilterMu.Lock()
+	defer debugFilterMu.Unlock()
+	debugFilter = patterns
+	debugFilterCache.Clear()
+}
+
+// callerPC returns the PC of a caller. 0 means the caller of the function calling callerPC.
+func callerPC(skip int) uintptr {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+3, pcs[:]) == 0 {
+		return 0
+	}
+	return pcs[0]
+}
+
+func callerFrame(pc uintptr) runtime.Frame {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return frame
+}
+
+// funcPackage returns the package path of a function name, such as "github.com/a/b" from
+// "github.com/a/b.(*T).Method".
+func funcPackage(funcName string) string {
+	slash := strings.LastIndexByte(funcName, '/')
+	if dot := strings.IndexByte(funcName[slash+1:], '.'); dot >= 0 {
+		return funcName[:slash+1+dot]
+	}
+	return funcName
+}
+
+func matchDebugFilter(patterns []string, pkg, file string) bool {
+	file = filepath.ToSlash(file)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, pkg); ok {
+			return true
+		}
+		// Try the trailing components of the filename.
+		for f := file; ; {
+			if ok, _ := path.Match(p, f); ok {
+				return true
+			}
+			i := strings.IndexByte(f, '/')
+			if i < 0 {
+				break
+			}
+			f = f[i+1:]
+		}
+	}
+	return false
+}
+
+// debugAllowed returns whether to show a debug message from pc, according to the debug filter.
+func debugAllowed(pc uintptr) bool {
+	debugFilterMu.RLock()
+	patterns := debugFilter
+	debugFilterMu.RUnlock()
+	if len(patterns) == 0 {
+		return true
+	}
+	if v, ok := debugFilterCache.Load(pc); ok {
+		return v.(bool)
+	}
+	frame := callerFrame(pc)
+	ret := matchDebugFilter(patterns, funcPackage(frame.Function), frame.File)
+	debugFilterCache.Store(pc, ret)
+	return ret
+}
+
+// goroutineID returns the ID of the current goroutine, parsed from the stack trace.
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// logDebugMessage logs a debug or verbose message from pc, applying the debug filter and
+// adding the caller information if enabled.
+func logDebugMessage(level slog.Level, pc uintptr, message string) {
+	if level < LevelVerbose && !debugAllowed(pc) {
+		return
+	}
+	var args []any
+	if logCaller.Load() {
+		frame := callerFrame(pc)
+		args = append(args, slog.String("caller", filepath.Base(frame.File)+":"+strconv.Itoa(frame.Line)))
+	}
+	if logGoroutine.Load() {
+		args = append(args, slog.Int64("goroutine", goroutineID()))
+	}
+	logMessage(level, message, args...)
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogCallerInfo(t *testing.T) {
+	withLogSettings(t)
+	defer SetLogCallerInfo(false, false)
+	SetLogLevel(LevelDebug)
+
+	SetLogCallerInfo(true, false)
+	out := captureStderr(t, func() { Debugf("x=%d", 1) })
+	_, line := GetSourceInfo()
+	assert.Equal(t, fmt.Sprintf("x=1 caller=debuginfo_test.go:%d\n", line-1), out)
+
+	out = captureStderr(t, func() { Verbose("v") })
+	_, line = GetSourceInfo()
+	assert.Equal(t, fmt.Sprintf("v caller=debuginfo_test.go:%d\n", line-1), out)
+
+	SetLogCallerInfo(false, true)
+	assert.Regexp(t, `^abc goroutine=\d+\n$`, captureStderr(t, func() { Debug("abc") }))
+
+	// Warnings don't get the caller information.
+	SetLogCallerInfo(true, true)
+	assert.Equal(t, MustGetBinName()+": w\n", captureStderr(t, func() { Warn("w") }))
+}
+
+func TestDebugFilter(t *testing.T) {
+	withLogSettings(t)
+	defer SetDebugFilter("")
+	SetLogLevel(LevelDebug)
+
+	for _, c := range []struct {
+		filter string
+		shown  bool
+	}{
+		{"", true},
+		{"debuginfo_test.go", true},
+		{"common/debuginfo_*.go", true},
+		{"src/common/*", true},
+		{"github.com/omakoto/go-common/src/common", true},
+		{"github.com/omakoto/go-common/src/*", true},
+		{"xxx.go, debuginfo_test.go", true},
+		{"common.go", false},
+		{"github.com/omakoto/go-common/src/cmdchain", false},
+	} {
+		SetDebugFilter(c.filter)
+		out := captureStderr(t, func() {
+			Debug("debug")
+			Verbose("verbose")
+		})
+		if c.shown {
+			assert.Equal(t, "debug\nverbose\n", out, c.filter)
+		} else {
+			assert.Equal(t, "verbose\n", out, c.filter)
+		}
+	}
+}
+
+func TestFuncPackage(t *testing.T) {
+	assert.Equal(t, "github.com/a/b", funcPackage("github.com/a/b.(*T).Method"))
+	assert.Equal(t, "github.com/a/b", funcPackage("github.com/a/b.Func.func1"))
+	assert.Equal(t, "main", funcPackage("main.main"))
+}
+
+func TestGoroutineID(t *testing.T) {
+	id := goroutineID()
+	assert.Greater(t, id, int64(0))
+
+	ch := make(chan int64)
+	go func() { ch <- goroutineID() }()
+	assert.NotEqual(t, id, <-ch)
+}
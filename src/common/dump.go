@@ -0,0 +1,366 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dumper converts values into human readable text or JSON, for debugging.
+//
+// Struct fields with the `dump:"redact"` tag, or with names in RedactFields, are shown as
+// "<redacted>". Fields with the `dump:"-"` tag are omitted.
+type Dumper struct {
+	// MaxDepth is the maximum nesting level of structs, maps, slices and pointers to show. 0 means unlimited.
+	MaxDepth int
+
+	// MaxLength is the maximum number of elements to show for each slice, array and map. 0 means unlimited.
+	MaxLength int
+
+	// RedactFields are field names, and map keys, to redact. Matching is case-insensitive.
+	RedactFields []string
+
+	// JSON makes Sdump return JSON instead of Go-like text.
+	JSON bool
+}
+
+// DefaultDumper is the Dumper used by Dump.
+var DefaultDumper = &Dumper{
+	MaxDepth:     10,
+	MaxLength:    100,
+	RedactFields: []string{"password", "passwd", "secret", "token", "apikey", "api_key", "credential", "credentials"},
+}
+
+const (
+	dumpRedacted = "<redacted>"
+	dumpMaxDepth = "<max depth>"
+	dumpCycle    = "<cycle>"
+)
+
+type dumpKind int
+
+const (
+	dumpScalar dumpKind = iota
+	dumpList
+	dumpMap
+)
+
+// dumpNode is an intermediate representation of a value.
+type dumpNode struct {
+	kind     dumpKind
+	typeName string
+
+	// scalar is the text of a scalar value, and isString tells whether it's a string.
+	scalar   string
+	isString bool
+	raw      any // Used for JSON.
+
+	// keys are used for dumpMap, and values are used for dumpList and dumpMap.
+	keys      []string
+	quoteKeys bool
+	values    []*dumpNode
+	more      int // Number of omitted elements.
+}
+
+func (d *Dumper) redacted(name string) bool {
+	for _, f := range d.RedactFields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func markerNode(s string) *dumpNode {
+	return &dumpNode{scalar: s, raw: s}
+}
+
+// dumpVisit identifies a pointer, a map or a slice being converted, to detect cycles.
+type dumpVisit struct {
+	p uintptr
+	n int // Length for slices, or -1.
+}
+
+// callString calls f, which is an Error or String method, recovering from a panic.
+func callString(f func() string) (s string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = fmt.Sprintf("<panic: %v>", r)
+			ok = false
+		}
+	}()
+	return f(), true
+}
+
+func (d *Dumper) convert(v reflect.Value, depth int, visiting map[dumpVisit]bool) *dumpNode {
+	if !v.IsValid() {
+		return &dumpNode{scalar: "nil"}
+	}
+	if v.CanInterface() && (v.Kind() != reflect.Pointer || !v.IsNil()) {
+		switch x := v.Interface().(type) {
+		case error:
+			s, ok := callString(x.Error)
+			if !ok {
+				return markerNode(s)
+			}
+			return &dumpNode{typeName: v.Type().String(), scalar: strconv.Quote(s), isString: true, raw: s}
+		case fmt.Stringer:
+			s, ok := callString(x.String)
+			if !ok {
+				return markerNode(s)
+			}
+			return &dumpNode{typeName: v.Type().String(), scalar: s, raw: s}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return &dumpNode{scalar: strconv.FormatBool(v.Bool()), raw: v.Bool()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &dumpNode{scalar: strconv.FormatInt(v.Int(), 10), raw: v.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &dumpNode{scalar: strconv.FormatUint(v.Uint(), 10), raw: v.Uint()}
+	case reflect.Float32, reflect.Float64:
+		return &dumpNode{scalar: strconv.FormatFloat(v.Float(), 'g', -1, 64), raw: v.Float()}
+	case reflect.Complex64, reflect.Complex128:
+		s := strconv.FormatComplex(v.Complex(), 'g', -1, 128)
+		return &dumpNode{scalar: s, raw: s}
+	case reflect.String:
+		return &dumpNode{scalar: strconv.Quote(v.String()), isString: true, raw: v.String()}
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if v.IsNil() {
+			return &dumpNode{typeName: v.Type().String(), scalar: "nil"}
+		}
+		s := fmt.Sprintf("%s(%#x)", v.Type(), v.Pointer())
+		return &dumpNode{scalar: s, raw: s}
+	case reflect.Interface:
+		return d.convert(v.Elem(), depth, visiting)
+	}
+
+	// Composite types.
+	if v.Kind() == reflect.Pointer || v.Kind() == reflect.Map || v.Kind() == reflect.Slice {
+		if v.IsNil() {
+			return &dumpNode{typeName: v.Type().String(), scalar: "nil"}
+		}
+		key := dumpVisit{v.Pointer(), -1}
+		if v.Kind() == reflect.Slice {
+			key.n = v.Len()
+		}
+		if visiting[key] {
+			return markerNode(dumpCycle)
+		}
+		visiting[key] = true
+		defer delete(visiting, key)
+	}
+	if d.MaxDepth > 0 && depth >= d.MaxDepth {
+		return markerNode(dumpMaxDepth)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		n := d.convert(v.Elem(), depth+1, visiting)
+		if n.typeName != "" {
+			n.typeName = "*" + n.typeName
+		}
+		return n
+
+	case reflect.Struct:
+		n := &dumpNode{kind: dumpMap, typeName: v.Type().String()}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("dump")
+			if tag == "-" {
+				continue
+			}
+			n.keys = append(n.keys, f.Name)
+			if tag == "redact" || d.redacted(f.Name) {
+				n.values = append(n.values, markerNode(dumpRedacted))
+				continue
+			}
+			n.values = append(n.values, d.convert(v.Field(i), depth+1, visiting))
+		}
+		return n
+
+	case reflect.Map:
+		n := &dumpNode{kind: dumpMap, typeName: v.Type().String(), quoteKeys: v.Type().Key().Kind() == reflect.String}
+		type entry struct {
+			key string
+			v   reflect.Value
+		}
+		var entries []entry
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			key := d.convert(k, depth+1, visiting)
+			ks := key.scalar
+			if key.isString {
+				ks = k.String()
+			}
+			entries = append(entries, entry{ks, iter.Value()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		if d.MaxLength > 0 && len(entries) > d.MaxLength {
+			n.more = len(entries) - d.MaxLength
+			entries = entries[:d.MaxLength]
+		}
+		for _, e := range entries {
+			n.keys = append(n.keys, e.key)
+			if d.redacted(e.key) {
+				n.values = append(n.values, markerNode(dumpRedacted))
+				continue
+			}
+			n.values = append(n.values, d.convert(e.v, depth+1, visiting))
+		}
+		return n
+
+	case reflect.Slice, reflect.Array:
+		n := &dumpNode{kind: dumpList, typeName: v.Type().String()}
+		l := v.Len()
+		if d.MaxLength > 0 && l > d.MaxLength {
+			n.more = l - d.MaxLength
+			l = d.MaxLength
+		}
+		for i := 0; i < l; i++ {
+			n.values = append(n.values, d.convert(v.Index(i), depth+1, visiting))
+		}
+		return n
+	}
+	return markerNode(fmt.Sprintf("<unsupported %s>", v.Type()))
+}
+
+func (n *dumpNode) writeText(sb *strings.Builder, indent string) {
+	if n.kind == dumpScalar {
+		switch {
+		case n.typeName == "" || n.isString:
+			sb.WriteString(n.scalar)
+		default:
+			fmt.Fprintf(sb, "%s(%s)", n.typeName, n.scalar)
+		}
+		return
+	}
+
+	sb.WriteString(n.typeName)
+	if len(n.values) == 0 && n.more == 0 {
+		sb.WriteString("{}")
+		return
+	}
+	sb.WriteString("{\n")
+	inner := indent + "  "
+	for i, v := range n.values {
+		sb.WriteString(inner)
+		if n.kind == dumpMap {
+			if n.quoteKeys {
+				sb.WriteString(strconv.Quote(n.keys[i]))
+			} else {
+				sb.WriteString(n.keys[i])
+			}
+			sb.WriteString(": ")
+		}
+		v.writeText(sb, inner)
+		sb.WriteString(",\n")
+	}
+	if n.more > 0 {
+		fmt.Fprintf(sb, "%s... (%d more)\n", inner, n.more)
+	}
+	sb.WriteString(indent)
+	sb.WriteString("}")
+}
+
+// marshalJSON is the same as json.Marshal, except it doesn't escape HTML characters, which
+// would make the markers unreadable.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (n *dumpNode) writeJSON(buf *bytes.Buffer) error {
+	switch n.kind {
+	case dumpScalar:
+		data, err := marshalJSON(n.raw)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		return nil
+	case dumpMap:
+		buf.WriteByte('{')
+		for i, v := range n.values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, err := marshalJSON(n.keys[i])
+			if err != nil {
+				return err
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			if err := v.writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		if n.more > 0 {
+			if len(n.values) > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, `"...":"%d more"`, n.more)
+		}
+		buf.WriteByte('}')
+	default:
+		buf.WriteByte('[')
+		for i, v := range n.values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := v.writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		if n.more > 0 {
+			if len(n.values) > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, `"... (%d more)"`, n.more)
+		}
+		buf.WriteByte(']')
+	}
+	return nil
+}
+
+// Sdump returns the text or JSON representation of object.
+func (d *Dumper) Sdump(object interface{}) string {
+	n := d.convert(reflect.ValueOf(object), 0, make(map[dumpVisit]bool))
+	if d.JSON {
+		var raw, buf bytes.Buffer
+		err := n.writeJSON(&raw)
+		if err == nil {
+			err = json.Indent(&buf, raw.Bytes(), "", "  ")
+		}
+		if err != nil {
+			return fmt.Sprintf("<unable to dump: %s>", err)
+		}
+		buf.WriteByte('\n')
+		return buf.String()
+	}
+	var sb strings.Builder
+	n.writeText(&sb, "")
+	sb.WriteByte('\n')
+	return sb.String()
+}
+
+// Dump prints object as a debug message, if DebugEnabled is true.
+func (d *Dumper) Dump(prefix string, object interface{}) {
+	if !DebugEnabled {
+		return
+	}
+	logDebugMessage(LevelDebug, callerPC(0), prefix+d.Sdump(object))
+}
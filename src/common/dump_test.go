@@ -0,0 +1,163 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dumpChild struct {
+	Values []int
+	Attrs  map[string]interface{}
+	Parent *dumpChild
+}
+
+type dumpTest struct {
+	Name     string
+	Password string
+	Key      string `dump:"redact"`
+	Ignored  int    `dump:"-"`
+	count    int
+	Child    *dumpChild
+	Empty    []string
+	Nil      *int
+	Err      error
+}
+
+func newDumpTest() dumpTest {
+	c := &dumpChild{
+		Values: []int{1, 2, 3, 4, 5},
+		Attrs:  map[string]interface{}{"token": "abc", "b": 1.5, "a": nil},
+	}
+	c.Parent = c
+	return dumpTest{
+		Name:     "name",
+		Password: "pass",
+		Key:      "key",
+		Ignored:  1,
+		count:    3,
+		Child:    c,
+		Empty:    []string{},
+		Err:      errors.New("bad"),
+	}
+}
+
+func TestDumperText(t *testing.T) {
+	d := &Dumper{MaxLength: 3, RedactFields: []string{"Password", "TOKEN"}}
+	assert.Equal(t, `common.dumpTest{
+  Name: "name",
+  Password: <redacted>,
+  Key: <redacted>,
+  count: 3,
+  Child: *common.dumpChild{
+    Values: []int{
+      1,
+      2,
+      3,
+      ... (2 more)
+    },
+    Attrs: map[string]interface {}{
+      "a": nil,
+      "b": 1.5,
+      "token": <redacted>,
+    },
+    Parent: <cycle>,
+  },
+  Empty: []string{},
+  Nil: *int(nil),
+  Err: "bad",
+}
+`, d.Sdump(newDumpTest()))
+
+	d = &Dumper{MaxDepth: 1}
+	assert.Equal(t, `common.dumpTest{
+  Name: "name",
+  Password: "pass",
+  Key: <redacted>,
+  count: 3,
+  Child: <max depth>,
+  Empty: <max depth>,
+  Nil: *int(nil),
+  Err: "bad",
+}
+`, d.Sdump(newDumpTest()))
+
+	assert.Equal(t, "nil\n", d.Sdump(nil))
+	assert.Equal(t, "3\n", d.Sdump(3))
+	assert.Equal(t, "map[int]string{\n  1: \"a\",\n}\n", d.Sdump(map[int]string{1: "a"}))
+}
+
+func TestDumperJSON(t *testing.T) {
+	d := &Dumper{MaxLength: 3, RedactFields: []string{"password", "token"}, JSON: true}
+	assert.Equal(t, `{
+  "Name": "name",
+  "Password": "<redacted>",
+  "Key": "<redacted>",
+  "count": 3,
+  "Child": {
+    "Values": [
+      1,
+      2,
+      3,
+      "... (2 more)"
+    ],
+    "Attrs": {
+      "a": null,
+      "b": 1.5,
+      "token": "<redacted>"
+    },
+    "Parent": "<cycle>"
+  },
+  "Empty": [],
+  "Nil": null,
+  "Err": "bad"
+}
+`, d.Sdump(newDumpTest()))
+}
+
+func TestDump(t *testing.T) {
+	withLogSettings(t)
+
+	SetLogLevel(LevelWarn)
+	assert.Equal(t, "", captureStderr(t, func() { Dump("x: ", 1) }))
+
+	SetLogLevel(LevelDebug)
+	assert.Equal(t, "x: common.dumpChild{\n  Values: []int{},\n  Attrs: map[string]interface {}{\n    \"password\": <redacted>,\n  },\n  Parent: *common.dumpChild(nil),\n}\n",
+		captureStderr(t, func() {
+			Dump("x: ", dumpChild{Values: []int{}, Attrs: map[string]interface{}{"password": "x"}})
+		}))
+}
+
+type panicStringer struct {
+	p *int
+}
+
+func (s panicStringer) String() string {
+	return fmt.Sprint(*s.p)
+}
+
+type panicError struct{}
+
+func (panicError) Error() string {
+	panic("bad error")
+}
+
+func TestDumperPanic(t *testing.T) {
+	d := &Dumper{}
+	assert.Equal(t, "<panic: runtime error: invalid memory address or nil pointer dereference>\n", d.Sdump(panicStringer{}))
+	assert.Equal(t, "[]error{\n  <panic: bad error>,\n}\n", d.Sdump([]error{panicError{}}))
+}
+
+func TestDumperSliceCycle(t *testing.T) {
+	s := make([]interface{}, 2)
+	s[0] = 1
+	s[1] = s
+	d := &Dumper{}
+	assert.Equal(t, "[]interface {}{\n  1,\n  <cycle>,\n}\n", d.Sdump(s))
+
+	// A sub-slice sharing the same array isn't a cycle.
+	a := []int{1, 2, 3}
+	assert.Equal(t, "[][]int{\n  []int{\n    1,\n    2,\n    3,\n  },\n  []int{\n    1,\n  },\n}\n", d.Sdump([][]int{a, a[:1]}))
+}
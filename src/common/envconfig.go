@@ -0,0 +1,338 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+// Size is a byte size, which LoadEnvConfig parses with ParseSize.
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"", 1},
+}
+
+// ParseSize parses a byte size such as "512", "10K", "1.5M" and "2GiB". Units are 1024-based.
+func ParseSize(s string) (Size, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "B")
+	for _, u := range sizeUnits {
+		num, ok := strings.CutSuffix(v, u.suffix)
+		if !ok && u.suffix != "" {
+			num, ok = strings.CutSuffix(v, u.suffix+"I")
+		}
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+		if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			break
+		}
+		// float64(math.MaxInt64) is rounded up to 2^63, which is out of range.
+		if f *= float64(u.scale); f >= float64(math.MaxInt64) {
+			return 0, fmt.Errorf("size \"%s\" is too large", s)
+		}
+		return Size(f), nil
+	}
+	return 0, fmt.Errorf("invalid size \"%s\"", s)
+}
+
+func (s Size) String() string {
+	for _, u := range sizeUnits {
+		if u.scale > 1 && s != 0 && int64(s)%u.scale == 0 {
+			return fmt.Sprintf("%d%s", int64(s)/u.scale, u.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+// envField is a struct field loaded by LoadEnvConfig.
+type envField struct {
+	field    reflect.StructField
+	name     string // Variable name without the binary name prefix.
+	def      string
+	hasDef   bool
+	required bool
+	enum     []string
+	sep      string
+	help     string
+}
+
+func toEnvName(fieldName string) string {
+	var sb strings.Builder
+	runes := []rune(fieldName)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
+
+// isSupportedEnvType returns whether LoadEnvConfig supports a field type.
+func isSupportedEnvType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// getEnvFields returns the fields of a config struct. It panics on unsupported field types,
+// so a bad struct is detected even when none of the variables are set.
+func getEnvFields(t reflect.Type) []envField {
+	var ret []envField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Tag.Get("env")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = toEnvName(f.Name)
+		}
+		if !isSupportedEnvType(f.Type) {
+			panic(fmt.Sprintf("Unsupported type %s of field %s.%s", f.Type, t.Name(), f.Name))
+		}
+		ef := envField{
+			field:    f,
+			name:     name,
+			required: f.Tag.Get("required") == "true",
+			sep:      f.Tag.Get("sep"),
+			help:     f.Tag.Get("help"),
+		}
+		ef.def, ef.hasDef = f.Tag.Lookup("default")
+		if e := f.Tag.Get("enum"); e != "" {
+			ef.enum = strings.Split(e, ",")
+		}
+		if ef.sep == "" {
+			ef.sep = ","
+		}
+		ret = append(ret, ef)
+	}
+	return ret
+}
+
+func mustGetConfigStruct(config any) reflect.Value {
+	v := reflect.ValueOf(config)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("Expected a pointer to a struct, but got %T", config))
+	}
+	return v.Elem()
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	sizeType     = reflect.TypeOf(Size(0))
+)
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+// setValue parses s and sets it to v.
+func (ef *envField) setValue(v reflect.Value, s string) error {
+	if len(ef.enum) > 0 {
+		found := false
+		for _, e := range ef.enum {
+			if e == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("must be one of %s", strings.Join(ef.enum, ", "))
+		}
+	}
+
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case sizeType:
+		sz, err := ParseSize(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(sz))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := parseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			panic(fmt.Sprintf("Unsupported field type %s", v.Type()))
+		}
+		var values []string
+		for _, e := range strings.Split(s, ef.sep) {
+			e = strings.TrimSpace(e)
+			if e != "" {
+				values = append(values, e)
+			}
+		}
+		sl := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, e := range values {
+			sl.Index(i).SetString(e)
+		}
+		v.Set(sl)
+	default:
+		panic(fmt.Sprintf("Unsupported field type %s", v.Type()))
+	}
+	return nil
+}
+
+func (ef *envField) typeName() string {
+	t := ef.field.Type
+	switch t {
+	case durationType:
+		return "duration"
+	case sizeType:
+		return "size"
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		return "list"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	}
+	return t.Kind().String()
+}
+
+// LoadEnvConfig fills a struct pointed by config from environmental variables named
+// <BINNAME>_<NAME>, where NAME is from the "env" tag of each field, or the field name
+// converted to upper snake case. Fields with `env:"-"` are ignored.
+//
+// Supported field types are string, bool, ints, uints, floats, time.Duration, Size and []string.
+// The following tags are also supported:
+//   - default: the value used when the variable isn't set.
+//   - required: if "true", the variable must be set.
+//   - enum: comma separated allowed values.
+//   - sep: separator for []string. (default: ",")
+//   - help: description used by EnvConfigHelp.
+//
+// All the invalid variables are reported in the returned error.
+func LoadEnvConfig(config any) error {
+	v := mustGetConfigStruct(config)
+
+	var errs []error
+	for _, ef := range getEnvFields(v.Type()) {
+		fv := v.FieldByIndex(ef.field.Index)
+		envName := BinEnvName(ef.name)
+		s, ok := os.LookupEnv(envName)
+		if !ok || s == "" {
+			if ef.required {
+				errs = append(errs, fmt.Errorf("%s must be set", envName))
+				continue
+			}
+			if !ef.hasDef {
+				continue
+			}
+			if err := ef.setValue(fv, ef.def); err != nil {
+				errs = append(errs, fmt.Errorf("invalid default value \"%s\" for %s: %w", ef.def, envName, err))
+			}
+			continue
+		}
+		if err := ef.setValue(fv, s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value \"%s\" for %s: %w", s, envName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MustLoadEnvConfig is the same as LoadEnvConfig, except it calls Fatal on error.
+func MustLoadEnvConfig(config any) {
+	Checke(LoadEnvConfig(config))
+}
+
+// EnvConfigHelp returns a help text describing the environmental variables LoadEnvConfig reads
+// for config, which is a pointer to a struct.
+func EnvConfigHelp(config any) string {
+	v := mustGetConfigStruct(config)
+
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	for _, ef := range getEnvFields(v.Type()) {
+		var notes []string
+		if len(ef.enum) > 0 {
+			notes = append(notes, "one of: "+strings.Join(ef.enum, ", "))
+		}
+		if ef.required {
+			notes = append(notes, "required")
+		} else if ef.hasDef {
+			notes = append(notes, "default: "+ef.def)
+		}
+		help := ef.help
+		if len(notes) > 0 {
+			if help != "" {
+				help += " "
+			}
+			help += "(" + strings.Join(notes, "; ") + ")"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", BinEnvName(ef.name), ef.typeName(), help)
+	}
+	_ = w.Flush()
+	return sb.String()
+}
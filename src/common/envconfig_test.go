@@ -0,0 +1,136 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEnvConfig struct {
+	Name     string        `help:"Name to use."`
+	Verbose  bool          `default:"false"`
+	Count    int           `default:"3"`
+	Timeout  time.Duration `env:"TIME_OUT" default:"5s" help:"Timeout."`
+	MaxSize  Size          `default:"1M"`
+	Tags     []string      `sep:":"`
+	Mode     string        `enum:"fast,slow" default:"fast"`
+	Ratio    float64
+	Ignored  string `env:"-"`
+	unexport string
+}
+
+func TestLoadEnvConfig(t *testing.T) {
+	{
+		var c testEnvConfig
+		assert.NoError(t, LoadEnvConfig(&c))
+		assert.Equal(t, testEnvConfig{Count: 3, Timeout: 5 * time.Second, MaxSize: 1 << 20, Mode: "fast"}, c)
+	}
+
+	{
+		t.Setenv(BinEnvName("NAME"), "abc")
+		t.Setenv(BinEnvName("VERBOSE"), "yes")
+		t.Setenv(BinEnvName("COUNT"), "0x10")
+		t.Setenv(BinEnvName("TIME_OUT"), "1m")
+		t.Setenv(BinEnvName("MAX_SIZE"), "2KiB")
+		t.Setenv(BinEnvName("TAGS"), "a: b::c")
+		t.Setenv(BinEnvName("MODE"), "slow")
+		t.Setenv(BinEnvName("RATIO"), "0.5")
+		t.Setenv(BinEnvName("IGNORED"), "x")
+
+		var c testEnvConfig
+		assert.NoError(t, LoadEnvConfig(&c))
+		assert.Equal(t, testEnvConfig{
+			Name:    "abc",
+			Verbose: true,
+			Count:   16,
+			Timeout: time.Minute,
+			MaxSize: 2048,
+			Tags:    []string{"a", "b", "c"},
+			Mode:    "slow",
+			Ratio:   0.5,
+		}, c)
+	}
+
+	{
+		t.Setenv(BinEnvName("COUNT"), "x")
+		t.Setenv(BinEnvName("TIME_OUT"), "1")
+		t.Setenv(BinEnvName("MODE"), "medium")
+
+		var c testEnvConfig
+		err := LoadEnvConfig(&c)
+		assert.Error(t, err)
+		lines := strings.Split(err.Error(), "\n")
+		assert.Equal(t, 3, len(lines), err.Error())
+		assert.Contains(t, lines[0], BinEnvName("COUNT"))
+		assert.Contains(t, lines[1], BinEnvName("TIME_OUT"))
+		assert.Contains(t, lines[2], "invalid value \"medium\" for "+BinEnvName("MODE")+": must be one of fast, slow")
+	}
+
+	{
+		var c struct {
+			Token string `required:"true"`
+		}
+		assert.EqualError(t, LoadEnvConfig(&c), BinEnvName("TOKEN")+" must be set")
+	}
+
+	assert.Panics(t, func() { _ = LoadEnvConfig(testEnvConfig{}) })
+
+	{
+		// Unsupported types are detected even when the variable isn't set.
+		var c struct {
+			Name  string
+			Ports []int
+		}
+		assert.PanicsWithValue(t, "Unsupported type []int of field .Ports", func() { _ = LoadEnvConfig(&c) })
+		assert.Panics(t, func() { _ = EnvConfigHelp(&c) })
+	}
+}
+
+func TestEnvConfigHelp(t *testing.T) {
+	help := EnvConfigHelp(&testEnvConfig{})
+	lines := strings.Split(strings.TrimSuffix(help, "\n"), "\n")
+	assert.Equal(t, 8, len(lines), help)
+	assert.Regexp(t, `^  `+BinEnvName("NAME")+` +string +Name to use\.$`, lines[0])
+	assert.Regexp(t, `^  `+BinEnvName("TIME_OUT")+` +duration +Timeout\. \(default: 5s\)$`, lines[3])
+	assert.Regexp(t, `^  `+BinEnvName("MODE")+` +string +\(one of: fast, slow; default: fast\)$`, lines[6])
+}
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]Size{
+		"0":     0,
+		"512":   512,
+		"512B":  512,
+		"10k":   10 << 10,
+		"1.5M":  3 << 19,
+		"2GiB":  2 << 30,
+		"1 TB":  1 << 40,
+		" 3mb ": 3 << 20,
+	} {
+		actual, err := ParseSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, actual, s)
+	}
+	for _, s := range []string{"", "abc", "-1", "10X", "B", "5I", "5IB", "inf", "NaN", "-inf", "1e30", "8E"} {
+		_, err := ParseSize(s)
+		assert.Error(t, err, s)
+	}
+	{
+		_, err := ParseSize("8388608T")
+		assert.EqualError(t, err, "size \"8388608T\" is too large")
+		actual, err := ParseSize("8388607T")
+		assert.NoError(t, err)
+		assert.Equal(t, Size(8388607<<40), actual)
+	}
+	assert.Equal(t, "10K", Size(10<<10).String())
+	assert.Equal(t, "1536", Size(1536).String())
+	assert.Equal(t, "0", Size(0).String())
+}
+
+func TestToEnvName(t *testing.T) {
+	assert.Equal(t, "MAX_SIZE", toEnvName("MaxSize"))
+	assert.Equal(t, "HTTP_SERVER", toEnvName("HTTPServer"))
+	assert.Equal(t, "URL", toEnvName("URL"))
+	assert.Equal(t, "A", toEnvName("A"))
+}
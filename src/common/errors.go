@@ -0,0 +1,123 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MultiError is an error consisting of multiple errors. errors.Is and errors.As check all of them.
+type MultiError struct {
+	Errors []error
+}
+
+// Error returns the message of the only error, or a numbered list of the messages.
+func (e *MultiError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d errors occurred:", len(e.Errors))
+	width := len(fmt.Sprint(len(e.Errors)))
+	for i, err := range e.Errors {
+		num := fmt.Sprintf("%*d. ", width, i+1)
+		lines := strings.Split(err.Error(), "\n")
+		fmt.Fprintf(&sb, "\n  %s%s", num, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(&sb, "\n  %s%s", strings.Repeat(" ", len(num)), line)
+		}
+	}
+	return sb.String()
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As.
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+// JoinErrors returns a *MultiError containing non-nil errors, or nil if there's none.
+// Errors that are *MultiError are flattened.
+func JoinErrors(errs ...error) error {
+	var ret []error
+	for _, err := range errs {
+		ret = appendError(ret, err)
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return &MultiError{ret}
+}
+
+func appendError(errs []error, err error) []error {
+	if err == nil {
+		return errs
+	}
+	if me, ok := err.(*MultiError); ok {
+		return append(errs, me.Errors...)
+	}
+	return append(errs, err)
+}
+
+// ErrorCollector collects errors, so batch operations can continue and fail at the end.
+// It's safe to use from multiple goroutines. The zero value is ready to use.
+type ErrorCollector struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// Adde adds err, if it's not nil. Returns whether err is not nil.
+func (c *ErrorCollector) Adde(err error) bool {
+	if err == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = appendError(c.errs, err)
+	return true
+}
+
+// Add adds err with a message in the same format as Check, if it's not nil. Returns whether
+// err is not nil.
+func (c *ErrorCollector) Add(err error, message string) bool {
+	if err == nil {
+		return false
+	}
+	return c.Adde(fmt.Errorf("%s: %w", message, err))
+}
+
+// Addf is the same as Add, except it takes a format.
+func (c *ErrorCollector) Addf(err error, format string, args ...interface{}) bool {
+	if err == nil {
+		return false
+	}
+	return c.Add(err, fmt.Sprintf(format, args...))
+}
+
+// Len returns the number of errors.
+func (c *ErrorCollector) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.errs)
+}
+
+// Errors returns the collected errors.
+func (c *ErrorCollector) Errors() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]error(nil), c.errs...)
+}
+
+// Err returns a *MultiError of the collected errors, or nil if there's none.
+func (c *ErrorCollector) Err() error {
+	return JoinErrors(c.Errors()...)
+}
+
+// CheckAll calls Fatal with all the collected errors, if any.
+func (c *ErrorCollector) CheckAll() {
+	Checke(c.Err())
+}
+
+// CheckAll calls Fatal with all the non-nil errors, if any.
+func CheckAll(errs ...error) {
+	Checke(JoinErrors(errs...))
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinErrors(t *testing.T) {
+	assert.Nil(t, JoinErrors())
+	assert.Nil(t, JoinErrors(nil, nil))
+
+	e1 := errors.New("first")
+	assert.EqualError(t, JoinErrors(nil, e1), "first")
+
+	err := JoinErrors(e1, errors.New("second\ndetails"), JoinErrors(errors.New("third")))
+	assert.EqualError(t, err, "3 errors occurred:\n  1. first\n  2. second\n     details\n  3. third")
+	assert.Len(t, err.(*MultiError).Errors, 3)
+	assert.ErrorIs(t, err, e1)
+
+	var errs []error
+	for i := 0; i < 10; i++ {
+		errs = append(errs, fmt.Errorf("e%d", i))
+	}
+	assert.Contains(t, JoinErrors(errs...).Error(), "\n   1. e0\n")
+	assert.Contains(t, JoinErrors(errs...).Error(), "\n  10. e9")
+}
+
+func TestErrorCollector(t *testing.T) {
+	var c ErrorCollector
+	assert.Nil(t, c.Err())
+	assert.False(t, c.Adde(nil))
+	assert.False(t, c.Add(nil, "x"))
+	assert.False(t, c.Addf(nil, "x%d", 1))
+
+	_, statErr := os.Stat("/nonexistent/file")
+	assert.True(t, c.Addf(statErr, "unable to read %s", "file"))
+	assert.True(t, c.Adde(errors.New("plain")))
+	assert.Equal(t, 2, c.Len())
+
+	err := c.Err()
+	assert.EqualError(t, err, "2 errors occurred:\n  1. unable to read file: stat /nonexistent/file: no such file or directory\n  2. plain")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	var pe *fs.PathError
+	assert.True(t, errors.As(err, &pe))
+	assert.Equal(t, "/nonexistent/file", pe.Path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Adde(errors.New("x"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 12, c.Len())
+}
+
+func TestCheckAll(t *testing.T) {
+	withLogSettings(t)
+	bin := MustGetBinName()
+
+	out := captureStderr(t, func() {
+		assert.Equal(t, 0, runWithRescue(func() int {
+			CheckAll(nil, nil)
+			var c ErrorCollector
+			c.CheckAll()
+			return 0
+		}))
+	})
+	assert.Equal(t, "", out)
+
+	out = captureStderr(t, func() {
+		assert.Equal(t, 1, runWithRescue(func() int {
+			var c ErrorCollector
+			c.Add(errors.New("a"), "step 1")
+			c.Add(errors.New("b"), "step 2")
+			c.CheckAll()
+			return 0
+		}))
+	})
+	assert.Equal(t, bin+": 2 errors occurred:\n"+bin+":   1. step 1: a\n"+bin+":   2. step 2: b\n", out)
+}
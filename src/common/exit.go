@@ -1,21 +1,128 @@
 package common
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type ExitFunc func()
 
+// Typical priorities of AtExit hooks. Hooks with higher priorities run first.
+const (
+	// ExitPriorityEarly is for hooks that need to run before others, such as restoring the terminal state.
+	ExitPriorityEarly = 100
+
+	// ExitPriorityDefault is the priority used by AtExit.
+	ExitPriorityDefault = 0
+
+	// ExitPriorityLate is for hooks that need to run after others, such as flushing output.
+	ExitPriorityLate = -100
+)
+
+// ExitHookOptions are options for AtExitWithOptions.
+type ExitHookOptions struct {
+	// Name is used in warnings when the hook panics or times out.
+	Name string
+
+	// Priority decides the order of the hooks. Hooks with higher priorities run first, and
+	// hooks with the same priority run in the reverse order of registration.
+	Priority int
+
+	// Timeout is the maximum time to wait for the hook to finish. 0 means no timeout.
+	// A hook that times out isn't stopped: it keeps running in the background while the
+	// following hooks run, so it may run concurrently with them.
+	Timeout time.Duration
+}
+
+// ExitHook is a handle to a registered AtExit hook.
+type ExitHook struct {
+	options ExitHookOptions
+	f       ExitFunc
+	seq     int64
+	removed bool
+}
+
 var (
 	atExitsSem = &sync.Mutex{}
-	atExits    []ExitFunc
+	atExits    []*ExitHook
+	atExitsSeq int64
+
+	exitSignalsSem = &sync.Mutex{}
+	exitSignals    []os.Signal
+
+	// exitSem is held while exiting due to a signal, so the main goroutine won't exit
+	// before the AtExit hooks finish.
+	exitSem = &sync.Mutex{}
+
+	// runAtExitsSem is held while running the AtExit hooks, so the hooks never run concurrently,
+	// e.g. from the signal handler and the main goroutine, except for the hooks that have timed
+	// out. (See ExitHookOptions.Timeout.)
+	runAtExitsSem = &sync.Mutex{}
+
+	// hookGoroutine is the ID of the goroutine running the current AtExit hook, so RunAtExits
+	// can detect calls from the hooks.
+	hookGoroutine atomic.Int64
 )
 
 type exitStatus struct {
 	code int
 }
 
+// ExitStatusUsage is the exit status for usage errors.
+const ExitStatusUsage = 2
+
+// ExitCoder is an error with an exit status, which RunAndExitErr uses as the process exit status.
+type ExitCoder interface {
+	error
+	ExitCode() int
+}
+
+// UsageError is an error caused by an invalid command line usage.
+type UsageError struct {
+	Message string
+}
+
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+// ExitCode returns ExitStatusUsage.
+func (e *UsageError) ExitCode() int {
+	return ExitStatusUsage
+}
+
+// Usagef creates a new UsageError.
+func Usagef(format string, args ...interface{}) error {
+	return &UsageError{fmt.Sprintf(format, args...)}
+}
+
+// ExitCodeOf returns the exit status for an error: 0 for nil, the exit code of an ExitCoder, or 1
+// for other errors. For an exec.ExitError killed by a signal, it returns 128 + the signal number.
+// An ExitCoder returning 0 also results in 1, because a non-nil error is a failure.
+func ExitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+	}
+	var ec ExitCoder
+	if errors.As(err, &ec) && ec.ExitCode() != 0 {
+		return ec.ExitCode()
+	}
+	return 1
+}
+
 // ExitSuccess should be used within RunAndExit to cleanly finishes the process with a success code.
 func ExitSuccess() {
 	Exit(true)
@@ -40,41 +147,135 @@ func ExitWithStatus(status int) {
 	panic(exitStatus{status})
 }
 
-// AtExit registers an at-exit hook function.
-func AtExit(f ExitFunc) {
+// AtExit registers an at-exit hook function with the default options.
+func AtExit(f ExitFunc) *ExitHook {
+	return AtExitWithOptions(ExitHookOptions{}, f)
+}
+
+// AtExitWithOptions registers an at-exit hook function.
+func AtExitWithOptions(options ExitHookOptions, f ExitFunc) *ExitHook {
+	atExitsSem.Lock()
+	defer atExitsSem.Unlock()
+
+	atExitsSeq++
+	h := &ExitHook{options: options, f: f, seq: atExitsSeq}
+	atExits = append(atExits, h)
+	return h
+}
+
+// Cancel unregisters the hook. Returns false if it has already run or been canceled.
+func (h *ExitHook) Cancel() bool {
 	atExitsSem.Lock()
-	atExits = append(atExits, f)
-	atExitsSem.Unlock()
+	defer atExitsSem.Unlock()
+
+	if h.removed {
+		return false
+	}
+	for i, e := range atExits {
+		if e == h {
+			atExits = append(atExits[:i], atExits[i+1:]...)
+			break
+		}
+	}
+	h.removed = true
+	return true
+}
+
+func (h *ExitHook) name() string {
+	if h.options.Name != "" {
+		return h.options.Name
+	}
+	return "(unnamed)"
+}
+
+// popNextAtExit removes and returns the hook to run next, or nil if there's none.
+func popNextAtExit() *ExitHook {
+	atExitsSem.Lock()
+	defer atExitsSem.Unlock()
+
+	if len(atExits) == 0 {
+		return nil
+	}
+	next := 0
+	for i, h := range atExits {
+		n := atExits[next]
+		if h.options.Priority > n.options.Priority || (h.options.Priority == n.options.Priority && h.seq > n.seq) {
+			next = i
+		}
+	}
+	ret := atExits[next]
+	atExits = append(atExits[:next], atExits[next+1:]...)
+	ret.removed = true
+	return ret
+}
+
+// call runs a hook, recovering from a panic.
+func (h *ExitHook) call() {
+	id := goroutineID()
+	prev := hookGoroutine.Swap(id)
+	// A hook that has timed out shouldn't overwrite the ID of the hook running now.
+	defer hookGoroutine.CompareAndSwap(id, prev)
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(exitStatus); ok {
+				Debugf("AtExit hook %s called ExitWithStatus(%d), which is ignored", h.name(), e.code)
+				return
+			}
+			Warnf("AtExit hook %s panicked: %v", h.name(), r)
+		}
+	}()
+	h.f()
+}
+
+func (h *ExitHook) run() {
+	if h.options.Timeout <= 0 {
+		h.call()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.call()
+	}()
+
+	timer := time.NewTimer(h.options.Timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		Warnf("AtExit hook %s didn't finish in %v", h.name(), h.options.Timeout)
+	}
 }
 
 // RunAtExits runs (and removes) all registered AtExit functions. RunAndExit will call it automatically,
 // so no need to call it when you use RunAndExit.
+// A panic in a hook is reported with Warn, and doesn't prevent the other hooks from running.
+// If it's called while the hooks are already running in another goroutine, it waits for them.
+// If it's called from a hook, it runs the remaining hooks right away.
 func RunAtExits() {
+	if hookGoroutine.Load() != goroutineID() {
+		runAtExitsSem.Lock()
+		defer runAtExitsSem.Unlock()
+	}
+
 	for {
-		atExitsSem.Lock()
-		if len(atExits) == 0 {
-			atExitsSem.Unlock()
+		h := popNextAtExit()
+		if h == nil {
 			return
 		}
-		last := len(atExits) - 1
-		lastFunc := atExits[last]
-		atExits[last] = nil
-		atExits = atExits[0:last]
-
-		atExitsSem.Unlock()
-
-		lastFunc()
+		h.run()
 	}
 }
 
 func runWithRescue(f func() int) (result int) {
+	startProfiling()
 	defer RunAtExits()
 	defer func() {
 		if r := recover(); r != nil {
 			if e, ok := r.(exitStatus); ok {
 				result = e.code
 			} else {
-				panic(r)
+				result = handleCrash(r)
 			}
 		}
 	}()
@@ -82,16 +283,119 @@ func runWithRescue(f func() int) (result int) {
 	return
 }
 
+// HandleSignals makes RunAndExit and RunAndExitIfFailure handle given signals (SIGINT and SIGTERM
+// if none is given): on the first signal, the AtExit hooks are executed and the process exits
+// with 128 + the signal number. If another signal arrives while running the hooks, the process
+// exits immediately.
+// It must be called before RunAndExit or RunAndExitIfFailure.
+func HandleSignals(signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	exitSignalsSem.Lock()
+	exitSignals = signals
+	exitSignalsSem.Unlock()
+}
+
+func signalExitStatus(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 128
+}
+
+// startSignalHandler starts handling the signals set by HandleSignals, if any, and returns
+// a function to stop it.
+func startSignalHandler() (stop func()) {
+	exitSignalsSem.Lock()
+	signals := exitSignals
+	exitSignalsSem.Unlock()
+
+	if len(signals) == 0 {
+		return func() {}
+	}
+
+	ch := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(ch, signals...)
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case sig := <-ch:
+			exitSem.Lock()
+			go func() {
+				sig := <-ch
+				Debugf("Received signal %v again, exiting immediately", sig)
+				os.Exit(signalExitStatus(sig))
+			}()
+			Debugf("Received signal %v, exiting", sig)
+			RunAtExits()
+			os.Exit(signalExitStatus(sig))
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+func exit(status int) {
+	exitSem.Lock()
+	os.Exit(status)
+}
+
+func runWithRescueErr(f func() error) int {
+	return runWithRescue(func() int {
+		err := f()
+		if err == nil {
+			return 0
+		}
+		printFatal(err.Error())
+		return ExitCodeOf(err)
+	})
+}
+
 // RunAndExit executes a given function. Within the function, util.Exit* functions can be used to finish the process cleanly.
+// If f panics, a crash report is written under $XDG_STATE_HOME/BINNAME/crash/ and the process exits
+// with ExitStatusCrash, unless <BIN>_CRASH_REPORT is "0".
+// Profiling and tracing can be enabled with <BIN>_CPUPROFILE, <BIN>_MEMPROFILE and <BIN>_TRACE, which
+// specify output filenames.
 func RunAndExit(f func() int) {
-	os.Exit(runWithRescue(f))
+	startSignalHandler()
+	exit(runWithRescue(f))
 }
 
 // RunAndExitIfFailure executes a given function. Within the function, util.Exit* functions can be used to finish the process cleanly.
 // When f returns 0, then this fucntion will *not* call exit(1).
 func RunAndExitIfFailure(f func() int) {
+	stop := startSignalHandler()
 	status := runWithRescue(f)
 	if status != 0 {
-		os.Exit(status)
+		exit(status)
 	}
+	stop()
+}
+
+// RunAndReturn is the same as RunAndExit, except it returns the exit status instead of exiting,
+// and doesn't handle signals. This is mainly for testing code that uses the util.Exit* functions
+// or Fatal.
+func RunAndReturn(f func() int) int {
+	return runWithRescue(f)
+}
+
+// RunAndReturnErr is the same as RunAndExitErr, except it returns the exit status instead of exiting,
+// and doesn't handle signals.
+func RunAndReturnErr(f func() error) int {
+	return runWithRescueErr(f)
+}
+
+// RunAndExitErr executes a given function, and exits with the status from the returned error.
+// If f returns an error, it's printed in the same way as Fatal, and the exit status is decided
+// by ExitCodeOf. Within the function, util.Exit* functions can be used to finish the process cleanly.
+func RunAndExitErr(f func() error) {
+	startSignalHandler()
+	exit(runWithRescueErr(f))
 }
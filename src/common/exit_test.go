@@ -1,7 +1,14 @@
 package common
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 )
@@ -19,3 +26,196 @@ func TestRunWithRescue(t *testing.T) {
 	CheckRunWithRescue(t, 0, func() int { ExitSuccess(); return -1 })
 	CheckRunWithRescue(t, 1, func() int { ExitFailure(); return -1 })
 }
+
+const envSignalHelper = "GO_COMMON_TEST_SIGNAL_HELPER"
+
+// TestSignalHelper is executed in a child process by TestHandleSignals.
+func TestSignalHelper(t *testing.T) {
+	mode := os.Getenv(envSignalHelper)
+	if mode == "" {
+		t.Skip("only used as a helper")
+	}
+	HandleSignals()
+	RunAndExit(func() int {
+		if mode == "return" {
+			// f returns while the hooks are running from the signal handler.
+			AtExitWithOptions(ExitHookOptions{Priority: ExitPriorityLate}, func() {
+				fmt.Println("late start")
+				fmt.Println("late end")
+			})
+			AtExitWithOptions(ExitHookOptions{Priority: ExitPriorityEarly}, func() {
+				fmt.Println("early start")
+				time.Sleep(300 * time.Millisecond)
+				fmt.Println("early end")
+			})
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+			time.Sleep(50 * time.Millisecond)
+			return 0
+		}
+		AtExit(func() {
+			fmt.Println("atexit")
+			if mode == "twice" {
+				syscall.Kill(os.Getpid(), syscall.SIGTERM)
+				time.Sleep(10 * time.Second)
+			}
+		})
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+		time.Sleep(10 * time.Second)
+		return 0
+	})
+}
+
+func TestHandleSignals(t *testing.T) {
+	for _, c := range []struct {
+		mode     string
+		expected int
+	}{
+		{"once", 128 + int(syscall.SIGINT)},
+		{"twice", 128 + int(syscall.SIGTERM)},
+		{"return", 128 + int(syscall.SIGINT)},
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSignalHelper$")
+		cmd.Env = append(os.Environ(), envSignalHelper+"="+c.mode)
+		out, err := cmd.Output()
+
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ee.ExitCode() != c.expected {
+			t.Errorf("mode=%s: expected exit status %d but was %d", c.mode, c.expected, ee.ExitCode())
+		}
+		if c.mode == "return" {
+			if string(out) != "early start\nearly end\nlate start\nlate end\n" {
+				t.Errorf("mode=%s: AtExit hooks ran concurrently: %q", c.mode, out)
+			}
+		} else if !strings.Contains(string(out), "atexit\n") {
+			t.Errorf("mode=%s: AtExit hook didn't run: %q", c.mode, out)
+		}
+	}
+}
+
+func TestAtExitOrder(t *testing.T) {
+	var order []string
+	add := func(name string, priority int) *ExitHook {
+		return AtExitWithOptions(ExitHookOptions{Name: name, Priority: priority}, func() {
+			order = append(order, name)
+		})
+	}
+	add("late", ExitPriorityLate)
+	add("default1", ExitPriorityDefault)
+	canceled := add("canceled", ExitPriorityDefault)
+	add("early", ExitPriorityEarly)
+	AtExit(func() { order = append(order, "default2") })
+	AtExit(func() { panic("boom") })
+
+	if !canceled.Cancel() {
+		t.Errorf("Cancel() expected to succeed")
+	}
+	if canceled.Cancel() {
+		t.Errorf("Cancel() expected to fail the second time")
+	}
+
+	RunAtExits()
+
+	expected := []string{"early", "default2", "default1", "late"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected=%v actual=%v", expected, order)
+	}
+}
+
+func TestAtExitTimeout(t *testing.T) {
+	ran := false
+	release := make(chan struct{})
+	defer close(release)
+
+	AtExitWithOptions(ExitHookOptions{Name: "after"}, func() { ran = true })
+	AtExitWithOptions(ExitHookOptions{Name: "hang", Timeout: 50 * time.Millisecond}, func() { <-release })
+
+	start := time.Now()
+	RunAtExits()
+	if !ran {
+		t.Errorf("Hook after the hanging one didn't run")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("RunAtExits took too long: %v", d)
+	}
+}
+
+func TestAtExitExitWithStatus(t *testing.T) {
+	level := GetLogLevel()
+	defer SetLogLevel(level)
+	SetLogLevel(LevelDebug)
+
+	ran := false
+	AtExitWithOptions(ExitHookOptions{Name: "after"}, func() { ran = true })
+	AtExitWithOptions(ExitHookOptions{Name: "exit"}, func() { ExitWithStatus(3) })
+	out := captureStderr(t, RunAtExits)
+	if !strings.Contains(out, "AtExit hook exit called ExitWithStatus(3), which is ignored") {
+		t.Errorf("Unexpected output: %q", out)
+	}
+	if !ran {
+		t.Errorf("Hook after ExitWithStatus didn't run")
+	}
+}
+
+func TestRunAtExitsFromHook(t *testing.T) {
+	for _, timeout := range []time.Duration{0, 5 * time.Second} {
+		var order []string
+		AtExitWithOptions(ExitHookOptions{Name: "late", Priority: ExitPriorityLate}, func() {
+			order = append(order, "late")
+		})
+		AtExitWithOptions(ExitHookOptions{Name: "reenter", Timeout: timeout}, func() {
+			order = append(order, "reenter")
+			RunAtExits()
+		})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			RunAtExits()
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("RunAtExits called from a hook deadlocked (timeout=%v)", timeout)
+		}
+		if strings.Join(order, ",") != "reenter,late" {
+			t.Errorf("Unexpected order %v (timeout=%v)", order, timeout)
+		}
+	}
+}
+
+type testExitCoder struct {
+	code int
+}
+
+func (testExitCoder) Error() string   { return "test" }
+func (e testExitCoder) ExitCode() int { return e.code }
+
+func TestRunWithRescueErr(t *testing.T) {
+	bin := MustGetBinName()
+
+	check := func(expected int, expectedOut string, f func() error) {
+		t.Helper()
+		var actual int
+		out := captureStderr(t, func() {
+			actual = runWithRescueErr(f)
+		})
+		if expected != actual {
+			t.Errorf("Expected=%d actual=%d", expected, actual)
+		}
+		if expectedOut != out {
+			t.Errorf("Expected output=%q actual=%q", expectedOut, out)
+		}
+	}
+
+	check(0, "", func() error { return nil })
+	check(1, bin+": failed\n", func() error { return errors.New("failed") })
+	check(2, bin+": bad flag -x\n", func() error { return Usagef("bad flag -%s", "x") })
+	check(42, bin+": wrapped: test\n", func() error { return fmt.Errorf("wrapped: %w", testExitCoder{42}) })
+	check(1, bin+": test\n", func() error { return testExitCoder{0} })
+	check(3, bin+": exit status 3\n", func() error { return exec.Command("sh", "-c", "exit 3").Run() })
+	check(128+int(syscall.SIGTERM), bin+": signal: terminated\n", func() error { return exec.Command("sh", "-c", "kill $$").Run() })
+	check(5, "", func() error { ExitWithStatus(5); return nil })
+}
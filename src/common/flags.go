@@ -0,0 +1,435 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// FlagSet is a command line parser supporting long (--name) and short (-n) options, and subcommands.
+// Each flag can also be set with an environmental variable, which is <BINNAME>_FLAG_<FLAG_NAME> for
+// the top level flags, and <BINNAME>_FLAG_<SUBCOMMAND>_<FLAG_NAME> for flags of subcommands.
+// (The "FLAG_" part avoids conflicts with the variables this package reads, such as <BINNAME>_DEBUG.)
+// Command line arguments take precedence over environmental variables.
+//
+// Parse errors are returned as *UsageError, so RunAndExitErr will exit with ExitStatusUsage.
+// "-h" and "--help" print the help and call ExitSuccess, so FlagSet should be used within RunAndExit*.
+type FlagSet struct {
+	name        string
+	description string
+	envPrefix   string
+	parent      *FlagSet
+
+	flags   []*Flag
+	byLong  map[string]*Flag
+	byShort map[rune]*Flag
+
+	subcommands []*FlagSet
+	run         func(args []string) error
+	version     bool
+
+	// Output is where the help is written. Defaults to os.Stdout.
+	Output io.Writer
+}
+
+// Flag is a single flag in a FlagSet.
+type Flag struct {
+	Long  string
+	Short rune
+	Help  string
+
+	// Env is the name of the environmental variable for the flag.
+	Env string
+
+	Value flag.Value
+
+	// DefValue is the default value as text, for the help.
+	DefValue string
+
+	// Set is whether the flag was set on the command line or with the environmental variable.
+	Set bool
+
+	setByEnv bool
+}
+
+type boolFlag interface {
+	IsBoolFlag() bool
+}
+
+// NewFlagSet creates a new top level FlagSet.
+func NewFlagSet(description string) *FlagSet {
+	return newFlagSet(MustGetBinName(), description, "FLAG_")
+}
+
+func newFlagSet(name, description, envPrefix string) *FlagSet {
+	return &FlagSet{
+		name:        name,
+		description: description,
+		envPrefix:   envPrefix,
+		byLong:      make(map[string]*Flag),
+		byShort:     make(map[rune]*Flag),
+	}
+}
+
+// Subcommand adds a subcommand, which is executed by Run. Flags for the subcommand should be added to
+// the returned FlagSet.
+func (fs *FlagSet) Subcommand(name, description string, run func(args []string) error) *FlagSet {
+	prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	sub := newFlagSet(fs.name+" "+name, description, fs.envPrefix+prefix)
+	sub.parent = fs
+	sub.run = run
+	fs.subcommands = append(fs.subcommands, sub)
+	return sub
+}
+
+// EnableVersion adds the "--version" flag, which prints VersionString() and calls ExitSuccess.
+func (fs *FlagSet) EnableVersion() *FlagSet {
+	if _, ok := fs.byLong["version"]; ok {
+		panic("Flag --version is already defined")
+	}
+	fs.version = true
+	return fs
+}
+
+// SetRun sets a function to execute from Run, for a FlagSet without subcommands.
+func (fs *FlagSet) SetRun(run func(args []string) error) *FlagSet {
+	fs.run = run
+	return fs
+}
+
+func (fs *FlagSet) shortName() string {
+	return fs.name[strings.LastIndexByte(fs.name, ' ')+1:]
+}
+
+// Var adds a flag with an arbitrary flag.Value. If the value has an IsBoolFlag() method returning
+// true, the flag doesn't take an argument. short can be 0 if the flag has no short name.
+func (fs *FlagSet) Var(value flag.Value, long string, short rune, help string) *Flag {
+	if _, ok := fs.byLong[long]; ok || long == "help" || (long == "version" && fs.version) {
+		panic(fmt.Sprintf("Flag --%s is already defined", long))
+	}
+	if _, ok := fs.byShort[short]; short != 0 && (ok || short == 'h') {
+		panic(fmt.Sprintf("Flag -%c is already defined", short))
+	}
+	f := &Flag{
+		Long:     long,
+		Short:    short,
+		Help:     help,
+		Env:      BinEnvName(fs.envPrefix + strings.ToUpper(strings.ReplaceAll(long, "-", "_"))),
+		Value:    value,
+		DefValue: value.String(),
+	}
+	fs.flags = append(fs.flags, f)
+	fs.byLong[long] = f
+	if short != 0 {
+		fs.byShort[short] = f
+	}
+	return f
+}
+
+type boolValue bool
+
+func (b *boolValue) Set(s string) error {
+	v, err := parseBool(s)
+	*b = boolValue(v)
+	return err
+}
+func (b *boolValue) String() string   { return strconv.FormatBool(bool(*b)) }
+func (b *boolValue) IsBoolFlag() bool { return true }
+
+type stringValue string
+
+func (s *stringValue) Set(v string) error { *s = stringValue(v); return nil }
+func (s *stringValue) String() string     { return string(*s) }
+
+type intValue int
+
+func (i *intValue) Set(s string) error {
+	v, err := strconv.ParseInt(s, 0, strconv.IntSize)
+	*i = intValue(v)
+	return err
+}
+func (i *intValue) String() string { return strconv.Itoa(int(*i)) }
+
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	*d = durationValue(v)
+	return err
+}
+func (d *durationValue) String() string { return time.Duration(*d).String() }
+
+type stringListValue []string
+
+func (l *stringListValue) Set(s string) error { *l = append(*l, s); return nil }
+func (l *stringListValue) String() string     { return strings.Join(*l, ",") }
+
+// Bool adds a boolean flag.
+func (fs *FlagSet) Bool(long string, short rune, value bool, help string) *bool {
+	p := &value
+	fs.Var((*boolValue)(p), long, short, help)
+	return p
+}
+
+// String adds a string flag.
+func (fs *FlagSet) String(long string, short rune, value string, help string) *string {
+	p := &value
+	fs.Var((*stringValue)(p), long, short, help)
+	return p
+}
+
+// Int adds an int flag.
+func (fs *FlagSet) Int(long string, short rune, value int, help string) *int {
+	p := &value
+	fs.Var((*intValue)(p), long, short, help)
+	return p
+}
+
+// Duration adds a time.Duration flag.
+func (fs *FlagSet) Duration(long string, short rune, value time.Duration, help string) *time.Duration {
+	p := &value
+	fs.Var((*durationValue)(p), long, short, help)
+	return p
+}
+
+// StringList adds a flag that can be specified multiple times. The environmental variable can
+// contain multiple values separated by commas.
+func (fs *FlagSet) StringList(long string, short rune, help string) *[]string {
+	p := &[]string{}
+	fs.Var((*stringListValue)(p), long, short, help)
+	return p
+}
+
+func isBool(f *Flag) bool {
+	b, ok := f.Value.(boolFlag)
+	return ok && b.IsBoolFlag()
+}
+
+func (fs *FlagSet) usageErrorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...) + " (see --help)"
+	if _, sub, ok := strings.Cut(fs.name, " "); ok {
+		// Include the subcommand names.
+		msg = sub + ": " + msg
+	}
+	return Usagef("%s", msg)
+}
+
+func (fs *FlagSet) setFromEnv() error {
+	for _, f := range fs.flags {
+		v := os.Getenv(f.Env)
+		if v == "" {
+			continue
+		}
+		values := []string{v}
+		if _, ok := f.Value.(*stringListValue); ok {
+			values = strings.Split(v, ",")
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return fs.usageErrorf("invalid value \"%s\" for $%s: %s", v, f.Env, err)
+			}
+		}
+		f.Set = true
+		f.setByEnv = true
+	}
+	return nil
+}
+
+func (fs *FlagSet) setFlag(f *Flag, name, value string) error {
+	if l, ok := f.Value.(*stringListValue); ok && f.setByEnv {
+		// The command line overrides the values from the environmental variable.
+		*l = nil
+		f.setByEnv = false
+	}
+	if err := f.Value.Set(value); err != nil {
+		return fs.usageErrorf("invalid value \"%s\" for %s: %s", value, name, err)
+	}
+	f.Set = true
+	return nil
+}
+
+// Parse parses the command line arguments (without the command name) and returns the remaining
+// arguments. If the FlagSet has subcommands, parsing stops at the first non-flag argument.
+// Otherwise, flags and non-flag arguments can be mixed. "--" ends the flags.
+func (fs *FlagSet) Parse(args []string) ([]string, error) {
+	if err := fs.setFromEnv(); err != nil {
+		return nil, err
+	}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(rest, args[i+1:]...), nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			if len(fs.subcommands) > 0 {
+				return append(rest, args[i:]...), nil
+			}
+			rest = append(rest, arg)
+			continue
+		}
+		if arg == "--help" || arg == "-h" {
+			fs.PrintHelp()
+			ExitSuccess()
+		}
+		if arg == "--version" && fs.version {
+			fmt.Fprintln(fs.getOutput(), VersionString())
+			ExitSuccess()
+		}
+
+		// nextValue consumes the next argument as a flag value.
+		nextValue := func(name string) (string, error) {
+			if i+1 >= len(args) {
+				return "", fs.usageErrorf("flag %s requires a value", name)
+			}
+			i++
+			return args[i], nil
+		}
+
+		if strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			f, ok := fs.byLong[name]
+			if !ok {
+				return nil, fs.usageErrorf("unknown flag --%s", name)
+			}
+			if !hasValue {
+				if isBool(f) {
+					value = "true"
+				} else {
+					var err error
+					if value, err = nextValue("--" + name); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err := fs.setFlag(f, "--"+name, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Short flags, possibly combined, as in "-abc", "-nVALUE" or "-n=VALUE".
+		shorts := []rune(arg[1:])
+		for j := 0; j < len(shorts); j++ {
+			name := "-" + string(shorts[j])
+			f, ok := fs.byShort[shorts[j]]
+			if !ok {
+				if shorts[j] == 'h' {
+					fs.PrintHelp()
+					ExitSuccess()
+				}
+				return nil, fs.usageErrorf("unknown flag %s", name)
+			}
+			var value string
+			switch {
+			case j+1 < len(shorts) && shorts[j+1] == '=':
+				value = string(shorts[j+2:])
+				j = len(shorts)
+			case isBool(f):
+				value = "true"
+			case j+1 < len(shorts):
+				value = string(shorts[j+1:])
+				j = len(shorts)
+			default:
+				var err error
+				if value, err = nextValue(name); err != nil {
+					return nil, err
+				}
+			}
+			if err := fs.setFlag(f, name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rest, nil
+}
+
+// Run parses the command line arguments, and executes the subcommand specified by the first
+// non-flag argument, or the function given to Subcommand if it's a subcommand without further subcommands.
+func (fs *FlagSet) Run(args []string) error {
+	rest, err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(fs.subcommands) == 0 {
+		if fs.run == nil {
+			panic(fmt.Sprintf("No subcommands or function set to %s", fs.name))
+		}
+		return fs.run(rest)
+	}
+	if len(rest) == 0 {
+		return fs.usageErrorf("command is missing")
+	}
+	for _, sub := range fs.subcommands {
+		if sub.shortName() == rest[0] {
+			return sub.Run(rest[1:])
+		}
+	}
+	return fs.usageErrorf("unknown command \"%s\"", rest[0])
+}
+
+func (fs *FlagSet) getOutput() io.Writer {
+	for s := fs; s != nil; s = s.parent {
+		if s.Output != nil {
+			return s.Output
+		}
+	}
+	return os.Stdout
+}
+
+// PrintHelp writes the help to the output.
+func (fs *FlagSet) PrintHelp() {
+	out := fs.getOutput()
+
+	usage := fs.name + " [options]"
+	if len(fs.subcommands) > 0 {
+		usage += " COMMAND [args...]"
+	} else {
+		usage += " [args...]"
+	}
+	fmt.Fprintf(out, "Usage: %s\n", usage)
+	if fs.description != "" {
+		fmt.Fprintf(out, "\n%s\n", fs.description)
+	}
+
+	fmt.Fprintf(out, "\nOptions:\n")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, f := range fs.flags {
+		names := "    "
+		if f.Short != 0 {
+			names = fmt.Sprintf("-%c, ", f.Short)
+		}
+		names += "--" + f.Long
+		if !isBool(f) {
+			names += "=VALUE"
+		}
+		help := f.Help
+		if def := f.DefValue; def != "" && def != "false" && def != "0" {
+			help += fmt.Sprintf(" (default: %s)", def)
+		}
+		fmt.Fprintf(w, "  %s\t%s [$%s]\n", names, strings.TrimSpace(help), f.Env)
+	}
+	fmt.Fprintf(w, "  -h, --help\tShow this help\n")
+	if fs.version {
+		fmt.Fprintf(w, "      --version\tShow version information\n")
+	}
+	_ = w.Flush()
+
+	if len(fs.subcommands) > 0 {
+		fmt.Fprintf(out, "\nCommands:\n")
+		subs := append([]*FlagSet(nil), fs.subcommands...)
+		sort.Slice(subs, func(i, j int) bool { return subs[i].name < subs[j].name })
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, sub := range subs {
+			desc, _, _ := strings.Cut(sub.description, "\n")
+			fmt.Fprintf(w, "  %s\t%s\n", sub.shortName(), desc)
+		}
+		_ = w.Flush()
+	}
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagSetParse(t *testing.T) {
+	newFlags := func() (*FlagSet, *bool, *bool, *int, *string, *time.Duration, *[]string) {
+		fs := NewFlagSet("test")
+		a := fs.Bool("all", 'a', false, "")
+		b := fs.Bool("brief", 'b', false, "")
+		n := fs.Int("count", 'n', 1, "")
+		s := fs.String("name", 0, "def", "")
+		d := fs.Duration("timeout", 't', time.Second, "")
+		l := fs.StringList("tag", 0, "")
+		return fs, a, b, n, s, d, l
+	}
+
+	{
+		fs, a, b, n, s, d, l := newFlags()
+		rest, err := fs.Parse([]string{"x", "-ab", "-n5", "--name", "foo", "y", "--timeout=1m", "--tag=t1", "--tag", "t2", "--", "-z"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"x", "y", "-z"}, rest)
+		assert.True(t, *a)
+		assert.True(t, *b)
+		assert.Equal(t, 5, *n)
+		assert.Equal(t, "foo", *s)
+		assert.Equal(t, time.Minute, *d)
+		assert.Equal(t, []string{"t1", "t2"}, *l)
+	}
+
+	{
+		fs, a, b, n, _, _, _ := newFlags()
+		rest, err := fs.Parse([]string{"-n=7", "-a=false", "-b=true"})
+		assert.NoError(t, err)
+		assert.Empty(t, rest)
+		assert.False(t, *a)
+		assert.True(t, *b)
+		assert.Equal(t, 7, *n)
+	}
+
+	{
+		fs, a, _, n, s, _, _ := newFlags()
+		rest, err := fs.Parse([]string{"-n", "7", "-a"})
+		assert.NoError(t, err)
+		assert.Empty(t, rest)
+		assert.True(t, *a)
+		assert.Equal(t, 7, *n)
+		assert.Equal(t, "def", *s)
+	}
+
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--unknown"}, "unknown flag --unknown (see --help)"},
+		{[]string{"-x"}, "unknown flag -x (see --help)"},
+		{[]string{"--count"}, "flag --count requires a value (see --help)"},
+		{[]string{"-n", "abc"}, `invalid value "abc" for -n: strconv.ParseInt: parsing "abc": invalid syntax (see --help)`},
+		{[]string{"--all=maybe"}, `invalid value "maybe" for --all: strconv.ParseBool: parsing "maybe": invalid syntax (see --help)`},
+	} {
+		fs, _, _, _, _, _, _ := newFlags()
+		_, err := fs.Parse(c.args)
+		assert.EqualError(t, err, c.expected)
+		assert.Equal(t, ExitStatusUsage, ExitCodeOf(err))
+	}
+}
+
+func TestFlagSetEnv(t *testing.T) {
+	fs := NewFlagSet("test")
+	n := fs.Int("max-count", 'n', 1, "")
+	l := fs.StringList("tag", 0, "")
+	v := fs.Bool("verbose", 0, false, "")
+
+	t.Setenv(BinEnvName("FLAG_MAX_COUNT"), "3")
+	t.Setenv(BinEnvName("FLAG_TAG"), "a,b")
+	t.Setenv(BinEnvName("FLAG_VERBOSE"), "1")
+
+	_, err := fs.Parse([]string{"--tag", "c", "-n", "5"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, *n)
+	assert.Equal(t, []string{"c"}, *l)
+	assert.True(t, *v)
+
+	t.Setenv(BinEnvName("FLAG_VERBOSE"), "x")
+	_, err = NewFlagSet("test").Parse(nil)
+	assert.NoError(t, err)
+
+	fs = NewFlagSet("test")
+	fs.Bool("verbose", 0, false, "")
+	_, err = fs.Parse(nil)
+	assert.ErrorContains(t, err, "invalid value \"x\" for $"+BinEnvName("FLAG_VERBOSE"))
+
+	// Flags don't share variables with the library.
+	t.Setenv(BinEnvName("DEBUG"), "1")
+	fs = NewFlagSet("test")
+	d := fs.Bool("debug", 0, false, "")
+	_, err = fs.Parse(nil)
+	assert.NoError(t, err)
+	assert.False(t, *d)
+}
+
+func TestFlagSetSubcommands(t *testing.T) {
+	var called string
+	var gotArgs []string
+
+	app := NewFlagSet("An app")
+	verbose := app.Bool("verbose", 'v', false, "Verbose output")
+
+	build := app.Subcommand("build", "Build things\nMore details.", func(args []string) error {
+		called = "build"
+		gotArgs = args
+		return nil
+	})
+	jobs := build.Int("jobs", 'j', 1, "Number of jobs")
+	app.Subcommand("clean", "Clean things", func(args []string) error {
+		called = "clean"
+		return nil
+	})
+
+	t.Setenv(BinEnvName("FLAG_BUILD_JOBS"), "4")
+
+	assert.NoError(t, app.Run([]string{"-v", "build", "x", "--jobs=8", "y"}))
+	assert.Equal(t, "build", called)
+	assert.Equal(t, []string{"x", "y"}, gotArgs)
+	assert.True(t, *verbose)
+	assert.Equal(t, 8, *jobs)
+
+	assert.NoError(t, app.Run([]string{"clean"}))
+	assert.Equal(t, "clean", called)
+
+	assert.EqualError(t, app.Run([]string{"test"}), `unknown command "test" (see --help)`)
+	assert.EqualError(t, app.Run(nil), `command is missing (see --help)`)
+	assert.EqualError(t, app.Run([]string{"build", "--xxx"}), `build: unknown flag --xxx (see --help)`)
+
+	var buf bytes.Buffer
+	app.Output = &buf
+	assert.Equal(t, 0, runWithRescue(func() int {
+		_ = app.Run([]string{"--help"})
+		return 1
+	}))
+	assert.Contains(t, buf.String(), "Usage: "+MustGetBinName()+" [options] COMMAND [args...]\n\nAn app\n")
+	assert.Regexp(t, `  -v, --verbose +Verbose output \[\$`+BinEnvName("FLAG_VERBOSE")+`\]\n`, buf.String())
+	assert.Regexp(t, `Commands:\n  build +Build things\n  clean +Clean things\n`, buf.String())
+
+	buf.Reset()
+	assert.Equal(t, 0, runWithRescue(func() int {
+		_ = app.Run([]string{"build", "-h"})
+		return 1
+	}))
+	assert.Contains(t, buf.String(), "Usage: "+MustGetBinName()+" build [options] [args...]\n")
+	assert.Regexp(t, `  -j, --jobs=VALUE +Number of jobs \(default: 1\) \[\$`+BinEnvName("FLAG_BUILD_JOBS")+`\]\n`, buf.String())
+}
+
+func TestFlagSetVersion(t *testing.T) {
+	var buf bytes.Buffer
+	app := NewFlagSet("An app").EnableVersion().SetRun(func(args []string) error { return nil })
+	app.Output = &buf
+
+	assert.Equal(t, 0, runWithRescue(func() int {
+		_ = app.Run([]string{"--version"})
+		return 1
+	}))
+	assert.Equal(t, VersionString()+"\n", buf.String())
+
+	buf.Reset()
+	app.PrintHelp()
+	assert.Regexp(t, `\n      --version +Show version information\n`, buf.String())
+
+	assert.Panics(t, func() { app.Bool("version", 0, false, "") })
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// instanceLockPollInterval is how often LockInstance retries while waiting for the lock.
+const instanceLockPollInterval = 100 * time.Millisecond
+
+// InstanceLockedError is returned when another process holds the instance lock.
+type InstanceLockedError struct {
+	// File is the lock filename.
+	File string
+
+	// Pid is the process ID of the holder, or 0 if unknown.
+	Pid int
+}
+
+func (e *InstanceLockedError) Error() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("another instance of %s is running", MustGetBinName())
+	}
+	return fmt.Sprintf("another instance of %s is running (pid %d)", MustGetBinName(), e.Pid)
+}
+
+// InstanceLock is an exclusive lock to prevent multiple instances of the binary from running at
+// the same time.
+type InstanceLock struct {
+	file *os.File
+	hook *ExitHook
+}
+
+func getInstanceLockFile() (string, error) {
+	dir, err := GetRuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "instance.lock"), nil
+}
+
+// TryLockInstance acquires the instance lock, which is a file locked with flock in the runtime
+// directory. If another process holds the lock, it returns *InstanceLockedError.
+// The lock is released by Unlock, or automatically by the AtExit hooks.
+func TryLockInstance() (*InstanceLock, error) {
+	filename, err := getInstanceLockFile()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &InstanceLockedError{File: filename, Pid: readLockPid(filename)}
+		}
+		return nil, fmt.Errorf("unable to lock %s: %w", filename, err)
+	}
+
+	// Record the pid, so other instances can tell who's holding the lock.
+	if err := file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		if err != nil {
+			Debugf("Unable to write pid to %s: %s", filename, err)
+		}
+	}
+
+	l := &InstanceLock{file: file}
+	l.hook = AtExitWithOptions(ExitHookOptions{Name: "InstanceLock"}, func() {
+		_ = l.unlock()
+	})
+	Debugf("Acquired instance lock %s", filename)
+	return l, nil
+}
+
+func readLockPid(filename string) int {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// LockInstance is the same as TryLockInstance, except it waits for the lock up to timeout.
+// A timeout of 0 or less means waiting forever.
+func LockInstance(timeout time.Duration) (*InstanceLock, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		l, err := TryLockInstance()
+		var le *InstanceLockedError
+		if !errors.As(err, &le) {
+			return l, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, err
+		}
+		Debugf("Waiting for instance lock: %s", err)
+		time.Sleep(instanceLockPollInterval)
+	}
+}
+
+// MustLockInstance is the same as LockInstance, except it calls Fatal on error.
+func MustLockInstance(timeout time.Duration) *InstanceLock {
+	l, err := LockInstance(timeout)
+	Checke(err)
+	return l
+}
+
+// Unlock releases the lock. Returns an error if it has already been released.
+func (l *InstanceLock) Unlock() error {
+	if !l.hook.Cancel() {
+		return errors.New("instance lock already released")
+	}
+	return l.unlock()
+}
+
+func (l *InstanceLock) unlock() error {
+	// Clear the pid before unlocking, but don't remove the file; removing it would let another process
+	// lock a new file while a third one still has the old one open.
+	_ = l.file.Truncate(0)
+	return l.file.Close()
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceLock(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	l, err := TryLockInstance()
+	assert.NoError(t, err)
+
+	_, err = TryLockInstance()
+	var le *InstanceLockedError
+	if assert.True(t, errors.As(err, &le)) {
+		assert.Equal(t, os.Getpid(), le.Pid)
+		assert.Contains(t, err.Error(), "is running (pid ")
+	}
+
+	start := time.Now()
+	_, err = LockInstance(200 * time.Millisecond)
+	assert.True(t, errors.As(err, &le))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	// Release the lock while waiting.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		assert.NoError(t, l.Unlock())
+	}()
+	l2, err := LockInstance(0)
+	assert.NoError(t, err)
+	assert.Error(t, l.Unlock())
+
+	// The lock is released by the AtExit hooks.
+	RunAtExits()
+	l3, err := TryLockInstance()
+	assert.NoError(t, err)
+	assert.NoError(t, l3.Unlock())
+	assert.Error(t, l2.Unlock())
+}
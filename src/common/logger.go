@@ -0,0 +1,338 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log levels used by the Debug / Verbose / Warn / Fatal functions.
+const (
+	LevelDebug   = slog.LevelDebug
+	LevelVerbose = slog.LevelInfo
+	LevelWarn    = slog.LevelWarn
+	LevelFatal   = slog.LevelError
+)
+
+// Log formats accepted by SetLogFormat.
+const (
+	// LogFormatPlain is the default format: "binname: message" for warnings and errors, and
+	// just the message for debug and verbose messages. Attributes are appended as key=value.
+	// Each line of multi-line warnings and errors gets the "binname: " prefix, and messages are
+	// colored depending on the level if enabled. See SetColorMode.
+	LogFormatPlain = "plain"
+
+	// LogFormatText uses slog.TextHandler.
+	LogFormatText = "text"
+
+	// LogFormatJSON uses slog.JSONHandler.
+	LogFormatJSON = "json"
+)
+
+var (
+	// logLevel is the minimum level to log. DebugEnabled and VerboseEnabled lower it.
+	logLevel = new(slog.LevelVar)
+
+	logMu         sync.Mutex
+	logFormat     = LogFormatPlain
+	logHandler    atomic.Pointer[slog.Handler]
+	logGeneration atomic.Int64
+
+	rootLogger = slog.New(&rootHandler{})
+)
+
+// initLogging sets up the log level and the format from the environmental variables. All the
+// variables affecting the level are read here, so the result doesn't depend on the
+// initialization order. (The log output and colors are set up separately in logsink.go and
+// color.go, which don't affect the level.)
+//   - <BIN>_LOG_LEVEL: the minimum level to log. (default: warn)
+//   - <BIN>_DEBUG or DEBUG: if "1", enables debug output regardless of <BIN>_LOG_LEVEL.
+//   - <BIN>_DEBUG_FILTER, <BIN>_LOG_CALLER, <BIN>_LOG_GOROUTINE: see initDebugInfo.
+//   - <BIN>_LOG_FORMAT: see SetLogFormat.
+func initLogging() {
+	logMu.Lock()
+	setLogHandlerLocked()
+	logMu.Unlock()
+
+	level := LevelWarn
+	var warnings []string
+	if l := GetBinEnv("LOG_LEVEL"); l != "" {
+		parsed, err := ParseLogLevel(l)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Invalid log level: %s", err))
+		} else {
+			level = parsed
+		}
+	}
+	if GetBinEnv("DEBUG") == "1" || os.Getenv("DEBUG") == "1" {
+		level = min(level, LevelDebug)
+	}
+	if initDebugInfo() {
+		level = min(level, LevelDebug)
+	}
+	SetLogLevel(level)
+
+	if f := GetBinEnv("LOG_FORMAT"); f != "" {
+		if err := SetLogFormat(f); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Invalid log format: %s", err))
+		}
+	}
+	for _, w := range warnings {
+		Warn(w)
+	}
+}
+
+// levelLeveler is a slog.Leveler returning the effective log level.
+type levelLeveler struct{}
+
+func (levelLeveler) Level() slog.Level {
+	l := logLevel.Level()
+	if DebugEnabled {
+		l = min(l, LevelDebug)
+	}
+	if VerboseEnabled {
+		l = min(l, LevelVerbose)
+	}
+	return l
+}
+
+// ParseLogLevel parses a level name (debug, verbose, info, warn, error, fatal) or a number.
+func ParseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "verbose", "info":
+		return LevelVerbose, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error", "fatal":
+		return LevelFatal, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("unknown log level \"%s\"", s)
+	}
+	return slog.Level(n), nil
+}
+
+// SetLogLevel sets the minimum level to log. It also updates DebugEnabled and VerboseEnabled.
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+	DebugEnabled = level <= LevelDebug
+	VerboseEnabled = level <= LevelVerbose
+}
+
+// GetLogLevel returns the effective minimum level to log.
+func GetLogLevel() slog.Level {
+	return levelLeveler{}.Level()
+}
+
+// SetLogFormat sets the output format, which is one of LogFormatPlain, LogFormatText and LogFormatJSON.
+func SetLogFormat(format string) error {
+	format = strings.ToLower(format)
+	switch format {
+	case LogFormatPlain, LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format \"%s\"", format)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logFormat = format
+	setLogHandlerLocked()
+	return nil
+}
+
+// setLogHandlerLocked re-creates the base handler. logMu must be held.
+func setLogHandlerLocked() {
+	var h slog.Handler
+	opts := &slog.HandlerOptions{Level: levelLeveler{}}
+	w := logWriter{}
+	switch logFormat {
+	case LogFormatText:
+		h = slog.NewTextHandler(w, opts)
+	case LogFormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		h = &plainHandler{w: w, mu: &sync.Mutex{}}
+	}
+	logHandler.Store(&h)
+	logGeneration.Add(1)
+}
+
+// Logger returns the root logger, which all the Debug / Verbose / Warn / Fatal functions use.
+func Logger() *slog.Logger {
+	return rootLogger
+}
+
+// NewLogger returns a sub-logger with a "logger" attribute, typically a package name.
+// Sub-loggers follow later changes to the log level and format.
+func NewLogger(name string) *slog.Logger {
+	return rootLogger.With("logger", name)
+}
+
+// rootHandler forwards to the current base handler, so loggers created before changing
+// the format will still use the new format.
+type rootHandler struct {
+	// ops are the WithAttrs / WithGroup calls to apply to the base handler.
+	ops []func(slog.Handler) slog.Handler
+
+	cacheMu   sync.Mutex
+	cached    slog.Handler
+	cachedGen int64
+}
+
+func (h *rootHandler) handler() slog.Handler {
+	gen := logGeneration.Load()
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+	if h.cached == nil || h.cachedGen != gen {
+		base := logHandler.Load()
+		ret := *base
+		for _, op := range h.ops {
+			ret = op(ret)
+		}
+		h.cached = ret
+		h.cachedGen = gen
+	}
+	return h.cached
+}
+
+func (h *rootHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler().Enabled(ctx, level)
+}
+
+func (h *rootHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *rootHandler) with(op func(slog.Handler) slog.Handler) *rootHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &rootHandler{ops: append(ops, op)}
+}
+
+func (h *rootHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler {
+		return base.WithAttrs(attrs)
+	})
+}
+
+func (h *rootHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(base slog.Handler) slog.Handler {
+		return base.WithGroup(name)
+	})
+}
+
+// plainHandler is a slog.Handler that produces the traditional output format.
+type plainHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	attrs  string
+	prefix string
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= levelLeveler{}.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var body strings.Builder
+	body.WriteString(r.Message)
+	body.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendPlainAttr(&body, h.prefix, a)
+		return true
+	})
+
+	prefix := ""
+	if r.Level >= LevelWarn {
+		prefix = MustGetBinName() + ": "
+	}
+	color := ""
+	if colorEnabled() {
+		color = levelColor(r.Level)
+	}
+
+	// Each line gets the prefix and the color.
+	var sb strings.Builder
+	for _, line := range strings.Split(body.String(), "\n") {
+		sb.WriteString(prefix)
+		if color != "" && line != "" {
+			sb.WriteString(color)
+			sb.WriteString(line)
+			sb.WriteString(colorReset)
+		} else {
+			sb.WriteString(line)
+		}
+		sb.WriteByte('\n')
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, sb.String())
+	return err
+}
+
+func appendPlainAttr(sb *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendPlainAttr(sb, p, ga)
+		}
+		return
+	}
+	v := a.Value.String()
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		v = strconv.Quote(v)
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(prefix)
+	sb.WriteString(a.Key)
+	sb.WriteByte('=')
+	sb.WriteString(v)
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendPlainAttr(&sb, h.prefix, a)
+	}
+	ret := *h
+	ret.attrs = sb.String()
+	return &ret
+}
+
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	ret := *h
+	ret.prefix += name + "."
+	return &ret
+}
+
+// logMessage logs a message with the root logger. A trailing newline in message is removed.
+func logMessage(level slog.Level, message string, args ...any) {
+	ctx := context.Background()
+	if !rootLogger.Enabled(ctx, level) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), level, strings.TrimSuffix(message, "\n"), 0)
+	r.Add(args...)
+	_ = rootLogger.Handler().Handle(ctx, r)
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureStderr runs f with os.Stderr redirected, and returns what was written.
+func captureStderr(t *testing.T, f func()) string {
+	temp, err := os.CreateTemp(t.TempDir(), "stderr*.txt")
+	assert.NoError(t, err)
+	defer temp.Close()
+
+	orig := os.Stderr
+	os.Stderr = temp
+	defer func() { os.Stderr = orig }()
+
+	f()
+
+	data, err := os.ReadFile(temp.Name())
+	assert.NoError(t, err)
+	return string(data)
+}
+
+// withLogSettings restores the log settings changed by a test.
+func withLogSettings(t *testing.T) {
+	origLevel := logLevel.Level()
+	origDebug := DebugEnabled
+	origVerbose := VerboseEnabled
+	t.Cleanup(func() {
+		logLevel.Set(origLevel)
+		DebugEnabled = origDebug
+		VerboseEnabled = origVerbose
+		assert.NoError(t, SetLogFormat(LogFormatPlain))
+	})
+}
+
+func TestPlainLogging(t *testing.T) {
+	withLogSettings(t)
+	SetLogLevel(LevelWarn)
+
+	bin := MustGetBinName()
+
+	assert.Equal(t, bin+": abc\n", captureStderr(t, func() { Warn("abc") }))
+	assert.Equal(t, bin+": abc\n", captureStderr(t, func() { Warn("abc\n") }))
+	assert.Equal(t, bin+": abc\n"+bin+": \n", captureStderr(t, func() { Warn("abc\n\n") }))
+	assert.Equal(t, bin+": abc\n"+bin+": def\n", captureStderr(t, func() { Warn("abc\ndef") }))
+	assert.Equal(t, bin+": x=1\n", captureStderr(t, func() { Warnf("x=%d", 1) }))
+	assert.Equal(t, "", captureStderr(t, func() { Debug("abc") }))
+	assert.Equal(t, "", captureStderr(t, func() { Verbose("abc") }))
+
+	DebugEnabled = true
+	assert.Equal(t, "abc\n", captureStderr(t, func() { Debug("abc") }))
+	assert.Equal(t, "abc\n", captureStderr(t, func() { Verbose("abc") }))
+	DebugEnabled = false
+
+	SetLogLevel(LevelVerbose)
+	assert.Equal(t, "", captureStderr(t, func() { Debug("abc") }))
+	assert.Equal(t, "abc\n", captureStderr(t, func() { Verbose("abc") }))
+
+	SetLogLevel(LevelFatal)
+	assert.Equal(t, "", captureStderr(t, func() { Warn("abc") }))
+}
+
+func TestStructuredLogging(t *testing.T) {
+	withLogSettings(t)
+	SetLogLevel(LevelVerbose)
+
+	bin := MustGetBinName()
+	log := NewLogger("pkg1")
+
+	assert.Equal(t, "hello logger=pkg1 a=1 b=\"x y\"\n", captureStderr(t, func() {
+		log.Info("hello", "a", 1, "b", "x y")
+	}))
+	assert.Equal(t, bin+": oops logger=pkg1 g.c=true\n", captureStderr(t, func() {
+		log.WithGroup("g").Warn("oops", "c", true)
+	}))
+	assert.Equal(t, "", captureStderr(t, func() {
+		log.Debug("hidden")
+	}))
+
+	// Sub-loggers created before changing the format follow the change.
+	assert.NoError(t, SetLogFormat(LogFormatJSON))
+	out := captureStderr(t, func() {
+		log.Info("hello", "a", 1)
+	})
+	var m map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(out), &m))
+	assert.Equal(t, "hello", m["msg"])
+	assert.Equal(t, "INFO", m["level"])
+	assert.Equal(t, "pkg1", m["logger"])
+	assert.Equal(t, 1.0, m["a"])
+
+	assert.NoError(t, SetLogFormat(LogFormatText))
+	out = captureStderr(t, func() {
+		Warn("abc\n")
+	})
+	assert.True(t, strings.HasSuffix(out, "level=WARN msg=abc\n"), out)
+
+	assert.Error(t, SetLogFormat("xml"))
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for s, expected := range map[string]any{
+		"debug":   LevelDebug,
+		"VERBOSE": LevelVerbose,
+		"info":    LevelVerbose,
+		"warn":    LevelWarn,
+		"error":   LevelFatal,
+		"fatal":   LevelFatal,
+		"-8":      LevelDebug - 4,
+	} {
+		l, err := ParseLogLevel(s)
+		assert.NoError(t, err)
+		assert.EqualValues(t, expected, l, s)
+	}
+	_, err := ParseLogLevel("xxx")
+	assert.Error(t, err)
+}
+
+func TestColorLogging(t *testing.T) {
+	withLogSettings(t)
+	defer SetColorMode(ColorAuto)
+	SetLogLevel(LevelDebug)
+
+	bin := MustGetBinName()
+
+	// Not a terminal.
+	assert.Equal(t, bin+": abc\n", captureStderr(t, func() { Warn("abc") }))
+
+	SetColorMode(ColorAlways)
+	assert.Equal(t, bin+": \x1b[33mabc\x1b[0m\n", captureStderr(t, func() { Warn("abc") }))
+	assert.Equal(t, bin+": \x1b[33mabc\x1b[0m\n"+bin+": \x1b[33mdef\x1b[0m\n", captureStderr(t, func() { Warn("abc\ndef") }))
+	assert.Equal(t, "\x1b[2mabc\x1b[0m\n", captureStderr(t, func() { Debug("abc") }))
+	assert.Equal(t, "abc\n", captureStderr(t, func() { Verbose("abc") }))
+	assert.Equal(t, bin+": \x1b[31mabc\x1b[0m\n", captureStderr(t, func() { printFatal("abc") }))
+
+	SetColorMode(ColorNever)
+	assert.Equal(t, bin+": abc\n", captureStderr(t, func() { Warn("abc") }))
+
+	SetColorMode(ColorAuto)
+	t.Setenv("NO_COLOR", "1")
+	assert.False(t, colorEnabled())
+}
+
+func TestInitLoggingFromEnv(t *testing.T) {
+	withLogSettings(t)
+	defer SetDebugFilter("")
+
+	for _, c := range []struct {
+		env      map[string]string
+		expected slog.Level
+	}{
+		{map[string]string{}, LevelWarn},
+		{map[string]string{"LOG_LEVEL": "info"}, LevelVerbose},
+		{map[string]string{"LOG_LEVEL": "error"}, LevelFatal},
+		{map[string]string{"LOG_LEVEL": "info", "DEBUG": "1"}, LevelDebug},
+		{map[string]string{"LOG_LEVEL": "error", "DEBUG_FILTER": "*.go"}, LevelDebug},
+		{map[string]string{"LOG_LEVEL": "-8", "DEBUG": "1"}, slog.Level(-8)},
+	} {
+		for _, name := range []string{"LOG_LEVEL", "DEBUG", "DEBUG_FILTER"} {
+			t.Setenv(BinEnvName(name), c.env[name])
+		}
+		t.Setenv("DEBUG", "")
+		initLogging()
+		assert.Equal(t, c.expected, GetLogLevel(), "%v", c.env)
+		assert.Equal(t, c.expected <= LevelDebug, DebugEnabled, "%v", c.env)
+	}
+}
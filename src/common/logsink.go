@@ -0,0 +1,235 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const defaultLogFileBackups = 3
+
+var (
+	logOutputMu    sync.Mutex
+	logOutput      io.Writer // nil means os.Stderr.
+	logOutputOwned bool      // Whether logOutput should be closed when replaced.
+)
+
+func init() {
+	if err := setLogOutputFromEnv(); err != nil {
+		Warnf("Unable to set log output: %s", err)
+	}
+}
+
+// setLogOutputFromEnv sets up the log output with the following variables:
+// <BIN>_LOG_FILE: Log filename.
+// <BIN>_LOG_FILE_MAX_SIZE: Rotate the log file when it gets larger than this size in bytes.
+// <BIN>_LOG_FILE_BACKUPS: Number of rotated files to keep. (default: 3)
+// <BIN>_LOG_SYSLOG: If "1" and no log file is set, send log to the local syslog.
+func setLogOutputFromEnv() error {
+	file := GetBinEnv("LOG_FILE")
+	if file == "" {
+		if GetBinEnv("LOG_SYSLOG") == "1" {
+			return SetSyslogOutput(MustGetBinName())
+		}
+		return nil
+	}
+	var maxSize int64
+	if s := GetBinEnv("LOG_FILE_MAX_SIZE"); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid max log file size \"%s\"", s)
+		}
+		maxSize = v
+	}
+	backups := defaultLogFileBackups
+	if s := GetBinEnv("LOG_FILE_BACKUPS"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid log file backups \"%s\"", s)
+		}
+		backups = v
+	}
+	if maxSize > 0 {
+		return SetRotatingLogFile(file, maxSize, backups)
+	}
+	return SetLogFile(file)
+}
+
+// logWriter writes to the current log output, which is os.Stderr by default.
+type logWriter struct{}
+
+func (logWriter) Write(data []byte) (int, error) {
+	logOutputMu.Lock()
+	defer logOutputMu.Unlock()
+
+	if logOutput == nil {
+		// Resolve os.Stderr on each write, so it can be replaced.
+		return os.Stderr.Write(data)
+	}
+	return logOutput.Write(data)
+}
+
+func setLogOutput(w io.Writer, owned bool) {
+	logOutputMu.Lock()
+	defer logOutputMu.Unlock()
+
+	if logOutputOwned {
+		if c, ok := logOutput.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+	logOutput = w
+	logOutputOwned = owned
+}
+
+// SetLogOutput sets the writer the Debug / Verbose / Warn / Fatal functions write to.
+// nil means os.Stderr. w won't be closed by this package.
+func SetLogOutput(w io.Writer) {
+	setLogOutput(w, false)
+}
+
+// SetLogFile makes the Debug / Verbose / Warn / Fatal functions append to a file.
+func SetLogFile(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	setLogOutput(f, true)
+	return nil
+}
+
+// SetRotatingLogFile makes the Debug / Verbose / Warn / Fatal functions append to a file,
+// which is rotated when it gets larger than maxSize bytes. See RotatingFile.
+func SetRotatingLogFile(filename string, maxSize int64, backups int) error {
+	f, err := NewRotatingFile(filename, maxSize, backups)
+	if err != nil {
+		return err
+	}
+	setLogOutput(f, true)
+	return nil
+}
+
+// SetSyslogOutput makes the Debug / Verbose / Warn / Fatal functions write to the local syslog.
+func SetSyslogOutput(tag string) error {
+	w, err := syslog.New(syslog.LOG_USER|syslog.LOG_NOTICE, tag)
+	if err != nil {
+		return err
+	}
+	setLogOutput(w, true)
+	return nil
+}
+
+// RotatingFile is an io.WriteCloser that writes to a file, and when the file gets larger than
+// a given size, renames it to FILENAME.1, FILENAME.1 to FILENAME.2, and so on, keeping
+// a given number of backups.
+type RotatingFile struct {
+	mu       sync.Mutex
+	filename string
+	maxSize  int64
+	backups  int
+
+	f    *os.File
+	size int64
+}
+
+// NewRotatingFile opens (or creates) a file for appending, which is rotated when it gets
+// larger than maxSize bytes.
+func NewRotatingFile(filename string, maxSize int64, backups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid max size %d", maxSize)
+	}
+	r := &RotatingFile{filename: filename, maxSize: maxSize, backups: max(backups, 0)}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f = f
+	r.size = st.Size()
+	return nil
+}
+
+func (r *RotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", r.filename, n)
+}
+
+// rotate rotates the files. The current file is kept open until the new file is opened, and
+// is moved back if the new file can't be opened, so on failure, writes continue to go to the
+// current file rather than being lost.
+func (r *RotatingFile) rotate() error {
+	if r.backups == 0 {
+		if err := r.f.Truncate(0); err != nil {
+			return err
+		}
+		r.size = 0
+		return nil
+	}
+	for i := r.backups - 1; i >= 1; i-- {
+		if err := os.Rename(r.backupName(i), r.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.filename, r.backupName(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	old := r.f
+	if err := r.open(); err != nil {
+		// Keep writing to the current file, under its original name, so later rotations
+		// won't move it down the backups.
+		_ = os.Rename(r.backupName(1), r.filename)
+		return err
+	}
+	_ = old.Close()
+	return nil
+}
+
+// Write writes data to the file, rotating it first if needed. If the rotation fails, data
+// is still written to the current file, and the rotation error is returned. The rotation
+// won't be retried until another maxSize bytes are written.
+func (r *RotatingFile) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if r.size > 0 && r.size+int64(len(data)) > r.maxSize {
+		if rotateErr = r.rotate(); rotateErr != nil {
+			r.size = 0
+		}
+	}
+	n, err := r.f.Write(data)
+	r.size += int64(n)
+	if err == nil && rotateErr != nil {
+		err = fmt.Errorf("unable to rotate %s: %w", r.filename, rotateErr)
+	}
+	return n, err
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,163 @@
+package common
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustReadFile(t *testing.T, filename string) string {
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestLogOutput(t *testing.T) {
+	defer SetLogOutput(nil)
+	bin := MustGetBinName()
+
+	var buf bytes.Buffer
+	SetLogOutput(&buf)
+	Warn("to buffer")
+	assert.Equal(t, bin+": to buffer\n", buf.String())
+
+	file := t.TempDir() + "/log.txt"
+	assert.NoError(t, SetLogFile(file))
+	Warn("to file 1")
+	Warn("to file 2")
+	assert.Equal(t, bin+": to file 1\n"+bin+": to file 2\n", mustReadFile(t, file))
+
+	// Switching back to stderr.
+	SetLogOutput(nil)
+	assert.Equal(t, bin+": to stderr\n", captureStderr(t, func() { Warn("to stderr") }))
+	assert.Equal(t, bin+": to buffer\n", buf.String())
+}
+
+func TestRotatingFile(t *testing.T) {
+	file := t.TempDir() + "/log.txt"
+
+	r, err := NewRotatingFile(file, 10, 2)
+	assert.NoError(t, err)
+
+	for _, s := range []string{"aaaaa\n", "bbbbb\n", "ccccc\n", "ddddd\n"} {
+		_, err := r.Write([]byte(s))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, r.Close())
+
+	assert.Equal(t, "ddddd\n", mustReadFile(t, file))
+	assert.Equal(t, "ccccc\n", mustReadFile(t, file+".1"))
+	assert.Equal(t, "bbbbb\n", mustReadFile(t, file+".2"))
+	assert.NoFileExists(t, file+".3")
+
+	// Appends to the existing file.
+	r, err = NewRotatingFile(file, 20, 0)
+	assert.NoError(t, err)
+	_, err = r.Write([]byte("eeeee\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ddddd\neeeee\n", mustReadFile(t, file))
+
+	// No backups.
+	_, err = r.Write([]byte("ffffffffffff\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ffffffffffff\n", mustReadFile(t, file))
+	assert.Equal(t, "ccccc\n", mustReadFile(t, file+".1"))
+	assert.NoError(t, r.Close())
+
+	_, err = r.Write([]byte("x"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+
+	_, err = NewRotatingFile(file, 0, 1)
+	assert.Error(t, err)
+}
+
+func TestRotatingFileRotationFailure(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/log.txt"
+
+	// A non-empty directory in the way makes the rename fail.
+	assert.NoError(t, os.MkdirAll(file+".1/x", 0700))
+
+	r, err := NewRotatingFile(file, 10, 1)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Write([]byte("aaaaa\n"))
+	assert.NoError(t, err)
+	n, err := r.Write([]byte("bbbbb\n"))
+	assert.ErrorContains(t, err, "unable to rotate")
+	assert.Equal(t, 6, n)
+
+	// Logs aren't lost, and rotation works again once the problem is fixed.
+	assert.Equal(t, "aaaaa\nbbbbb\n", mustReadFile(t, file))
+	assert.NoError(t, os.RemoveAll(file+".1"))
+	_, err = r.Write([]byte("ccccc\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ccccc\n", mustReadFile(t, file))
+	assert.Equal(t, "aaaaa\nbbbbb\n", mustReadFile(t, file+".1"))
+}
+
+func TestRotatingFileReopenFailure(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/log.txt"
+
+	r, err := NewRotatingFile(file, 10, 2)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Write([]byte("aaaaa\n"))
+	assert.NoError(t, err)
+
+	// Running out of file descriptors makes the reopen fail, after the rename succeeds.
+	var limit syscall.Rlimit
+	assert.NoError(t, syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit))
+	f, err := os.Open(os.DevNull)
+	assert.NoError(t, err)
+	lowLimit := limit
+	lowLimit.Cur = uint64(f.Fd())
+	_ = f.Close()
+	assert.NoError(t, syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowLimit))
+
+	_, err1 := r.Write([]byte("bbbbb\n"))
+	_, err2 := r.Write([]byte("cc\n"))
+	assert.NoError(t, syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit))
+
+	assert.ErrorContains(t, err1, "unable to rotate")
+	assert.NoError(t, err2, "rotation shouldn't be retried right away")
+
+	// The current file stays in place, and nothing is lost.
+	assert.Equal(t, "aaaaa\nbbbbb\ncc\n", mustReadFile(t, file))
+	assert.NoFileExists(t, file+".1")
+	assert.NoFileExists(t, file+".2")
+
+	_, err = r.Write([]byte("ddddd\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ddddd\n", mustReadFile(t, file))
+	assert.Equal(t, "aaaaa\nbbbbb\ncc\n", mustReadFile(t, file+".1"))
+}
+
+func TestRotatingLogFileConcurrent(t *testing.T) {
+	defer SetLogOutput(nil)
+
+	file := t.TempDir() + "/log.txt"
+	assert.NoError(t, SetRotatingLogFile(file, 1000, 1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				Warn("message")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.FileExists(t, file+".1")
+	assert.LessOrEqual(t, len(mustReadFile(t, file)), 1000)
+}
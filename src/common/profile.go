@@ -0,0 +1,78 @@
+package common
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+)
+
+// startProfiling starts profiling and tracing requested by environmental variables, and registers
+// AtExit hooks to stop them and flush the files.
+//   - <BIN>_CPUPROFILE: filename to write a CPU profile to.
+//   - <BIN>_MEMPROFILE: filename to write a heap profile to at exit. Use
+//     "go tool pprof -sample_index=alloc_space" to see all the allocations rather than the in-use memory.
+//   - <BIN>_MEMPROFILE_RATE: overrides runtime.MemProfileRate.
+//   - <BIN>_TRACE: filename to write a runtime trace to.
+func startProfiling() {
+	if file := GetBinEnv("CPUPROFILE"); file != "" {
+		startProfile("CPU profile", file, pprof.StartCPUProfile, pprof.StopCPUProfile)
+	}
+	if file := GetBinEnv("TRACE"); file != "" {
+		startProfile("trace", file, trace.Start, trace.Stop)
+	}
+	if file := GetBinEnv("MEMPROFILE"); file != "" {
+		if rate := GetBinEnv("MEMPROFILE_RATE"); rate != "" {
+			r, err := strconv.Atoi(rate)
+			if err != nil {
+				Warnf("Invalid %s: %s", BinEnvName("MEMPROFILE_RATE"), rate)
+			} else {
+				runtime.MemProfileRate = r
+			}
+		}
+		AtExitWithOptions(ExitHookOptions{Name: "MemProfile", Priority: ExitPriorityLate}, func() {
+			writeHeapProfile(file)
+		})
+	}
+}
+
+// startProfile creates file and calls start with it, and registers an AtExit hook to call stop
+// and close the file.
+func startProfile(what, file string, start func(w io.Writer) error, stop func()) {
+	out, err := os.Create(file)
+	if err != nil {
+		Warnf("Unable to create %s file: %s", what, err)
+		return
+	}
+	if err := start(out); err != nil {
+		Warnf("Unable to start %s: %s", what, err)
+		out.Close()
+		return
+	}
+	Debugf("Writing %s to %s", what, file)
+	AtExitWithOptions(ExitHookOptions{Name: what, Priority: ExitPriorityLate}, func() {
+		stop()
+		if err := out.Close(); err != nil {
+			Warnf("Unable to write %s file: %s", what, err)
+		}
+	})
+}
+
+func writeHeapProfile(file string) {
+	out, err := os.Create(file)
+	if err != nil {
+		Warnf("Unable to create heap profile file: %s", err)
+		return
+	}
+	defer out.Close()
+
+	// Get up-to-date statistics.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(out); err != nil {
+		Warnf("Unable to write heap profile: %s", err)
+		return
+	}
+	Debugf("Wrote heap profile to %s", file)
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfiling(t *testing.T) {
+	dir := t.TempDir()
+	cpu := filepath.Join(dir, "cpu.prof")
+	mem := filepath.Join(dir, "mem.prof")
+	tr := filepath.Join(dir, "trace.out")
+
+	t.Setenv(BinEnvName("CPUPROFILE"), cpu)
+	t.Setenv(BinEnvName("MEMPROFILE"), mem)
+	t.Setenv(BinEnvName("TRACE"), tr)
+
+	status := runWithRescue(func() int {
+		sum := 0
+		for i := 0; i < 1000000; i++ {
+			sum += len(make([]byte, i%100))
+		}
+		return sum % 2
+	})
+	assert.Equal(t, 0, status)
+
+	for _, file := range []string{cpu, mem, tr} {
+		st, err := os.Stat(file)
+		if assert.NoError(t, err) {
+			assert.NotZero(t, st.Size(), file)
+		}
+	}
+}
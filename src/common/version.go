@@ -0,0 +1,133 @@
+package common
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BuildVersion and BuildTime can be set with -ldflags, as in
+// "-ldflags '-X github.com/omakoto/go-common/src/common.BuildVersion=v1.2.3'", to override
+// the ones from the build information. BuildTime should be in RFC 3339.
+var (
+	BuildVersion = ""
+	BuildTime    = ""
+)
+
+// BuildInfo is the version information of the running binary.
+type BuildInfo struct {
+	// Path is the main package path.
+	Path string
+
+	// Version is the main module version, which is "(devel)" when built from a local checkout.
+	Version string
+
+	// GoVersion is the Go version used to build the binary.
+	GoVersion string
+
+	// Revision is the VCS revision, if available.
+	Revision string
+
+	// Dirty is whether the source tree had local modifications.
+	Dirty bool
+
+	// Time is BuildTime if set, or the VCS commit time. Zero if unavailable.
+	Time time.Time
+}
+
+var (
+	buildInfoOnce sync.Once
+	buildInfo     *BuildInfo
+)
+
+// GetBuildInfo returns the version information of the running binary. The fields are empty if
+// the binary wasn't built with module support.
+func GetBuildInfo() *BuildInfo {
+	buildInfoOnce.Do(func() {
+		buildInfo = readBuildInfo()
+	})
+	return buildInfo
+}
+
+func readBuildInfo() *BuildInfo {
+	ret := &BuildInfo{GoVersion: runtime.Version()}
+	var vcsTime string
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		ret.Path = bi.Path
+		ret.Version = bi.Main.Version
+		ret.GoVersion = bi.GoVersion
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				ret.Revision = s.Value
+			case "vcs.modified":
+				ret.Dirty = s.Value == "true"
+			case "vcs.time":
+				vcsTime = s.Value
+			}
+		}
+	}
+	if BuildVersion != "" {
+		ret.Version = BuildVersion
+	}
+	t := vcsTime
+	if BuildTime != "" {
+		t = BuildTime
+	}
+	if t != "" {
+		if parsed, err := time.Parse(time.RFC3339, t); err == nil {
+			ret.Time = parsed
+		} else {
+			Debugf("Invalid build time \"%s\": %s", t, err)
+		}
+	}
+	return ret
+}
+
+// ShortRevision returns the first 12 characters of the revision, with "-dirty" if the source
+// tree had local modifications.
+func (b *BuildInfo) ShortRevision() string {
+	rev := b.Revision
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if rev != "" && b.Dirty {
+		rev += "-dirty"
+	}
+	return rev
+}
+
+// String returns the version, the revision and the time in a single line, as in
+// "v1.2.3 (0123456789ab-dirty, 2024-01-02T03:04:05Z)".
+func (b *BuildInfo) String() string {
+	version := b.Version
+	if version == "" {
+		version = "(unknown)"
+	}
+	var details []string
+	if rev := b.ShortRevision(); rev != "" {
+		details = append(details, rev)
+	}
+	if !b.Time.IsZero() {
+		details = append(details, b.Time.UTC().Format(time.RFC3339))
+	}
+	if len(details) == 0 {
+		return version
+	}
+	return fmt.Sprintf("%s (%s)", version, strings.Join(details, ", "))
+}
+
+// VersionString returns a version text suitable for a --version flag, as in
+// "mytool v1.2.3 (0123456789ab, 2024-01-02T03:04:05Z) go1.23.0 linux/amd64".
+func VersionString() string {
+	b := GetBuildInfo()
+	return fmt.Sprintf("%s %s %s %s/%s", MustGetBinName(), b, b.GoVersion, runtime.GOOS, runtime.GOARCH)
+}
+
+// DumpBuildInfo prints the build information with Dump, when debug output is enabled.
+func DumpBuildInfo() {
+	Dump("Build info: ", GetBuildInfo())
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInfoString(t *testing.T) {
+	b := &BuildInfo{Version: "v1.2.3"}
+	assert.Equal(t, "v1.2.3", b.String())
+
+	b.Revision = "0123456789abcdef"
+	assert.Equal(t, "v1.2.3 (0123456789ab)", b.String())
+
+	b.Dirty = true
+	b.Time = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, "v1.2.3 (0123456789ab-dirty, 2024-01-02T03:04:05Z)", b.String())
+
+	assert.Equal(t, "(unknown)", (&BuildInfo{}).String())
+}
+
+func TestReadBuildInfo(t *testing.T) {
+	origVersion, origTime := BuildVersion, BuildTime
+	defer func() { BuildVersion, BuildTime = origVersion, origTime }()
+
+	BuildVersion = "v9.9.9"
+	BuildTime = "2024-01-02T03:04:05Z"
+	b := readBuildInfo()
+	assert.Equal(t, "v9.9.9", b.Version)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), b.Time.UTC())
+	assert.Equal(t, runtime.Version(), b.GoVersion)
+}
+
+func TestVersionString(t *testing.T) {
+	v := VersionString()
+	assert.True(t, strings.HasPrefix(v, MustGetBinName()+" "), v)
+	assert.True(t, strings.HasSuffix(v, " "+runtime.GOOS+"/"+runtime.GOARCH), v)
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WarnLimiter limits the number of warnings, to prevent a loop from flooding the output.
+// The number of suppressed warnings is reported at exit, by the AtExit hooks.
+type WarnLimiter struct {
+	name     string
+	limit    int
+	interval time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+	suppressed  int
+}
+
+var (
+	warnLimitersMu   sync.Mutex
+	siteWarnLimiters = make(map[callSite]*WarnLimiter)
+
+	// suppressedLimiters are limiters that have suppressed warnings since the last summary.
+	suppressedLimiters []*WarnLimiter
+	warnSummaryHook    *ExitHook
+)
+
+// NewWarnLimiter creates a WarnLimiter that prints up to limit warnings per interval. If interval is 0,
+// it prints up to limit warnings in total. name is used in the summary of suppressed warnings.
+func NewWarnLimiter(name string, limit int, interval time.Duration) *WarnLimiter {
+	return &WarnLimiter{name: name, limit: limit, interval: interval}
+}
+
+// allow returns whether to print a warning now, and counts it as suppressed if not.
+func (l *WarnLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.interval > 0 && now.Sub(l.windowStart) >= l.interval {
+		l.windowStart = now
+		l.count = 0
+	}
+	if l.count < l.limit {
+		l.count++
+		return true
+	}
+	l.suppressed++
+	if l.suppressed == 1 {
+		addSuppressedLimiter(l)
+	}
+	return false
+}
+
+// Warnf is the same as the global Warnf, except it doesn't print anything if the limit has been
+// reached. Returns whether the warning was printed.
+func (l *WarnLimiter) Warnf(format string, args ...interface{}) bool {
+	if !l.allow() {
+		return false
+	}
+	Warnf(format, args...)
+	return true
+}
+
+// Suppressed returns the number of warnings suppressed since the last summary.
+func (l *WarnLimiter) Suppressed() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.suppressed
+}
+
+func addSuppressedLimiter(l *WarnLimiter) {
+	warnLimitersMu.Lock()
+	defer warnLimitersMu.Unlock()
+
+	suppressedLimiters = append(suppressedLimiters, l)
+	if warnSummaryHook == nil {
+		warnSummaryHook = AtExitWithOptions(ExitHookOptions{Name: "WarnSummary"}, PrintSuppressedWarnings)
+	}
+}
+
+// PrintSuppressedWarnings prints the number of warnings suppressed by WarnLimiters, WarnOncef and
+// WarnLimitedf, and resets the counts. RunAndExit calls it automatically via the AtExit hooks.
+func PrintSuppressedWarnings() {
+	warnLimitersMu.Lock()
+	limiters := suppressedLimiters
+	suppressedLimiters = nil
+	if warnSummaryHook != nil {
+		warnSummaryHook.Cancel()
+		warnSummaryHook = nil
+	}
+	warnLimitersMu.Unlock()
+
+	sort.SliceStable(limiters, func(i, j int) bool { return limiters[i].name < limiters[j].name })
+	for _, l := range limiters {
+		l.mu.Lock()
+		n := l.suppressed
+		l.suppressed = 0
+		l.mu.Unlock()
+
+		if n > 0 {
+			Warnf("%d warning(s) from %s suppressed", n, l.name)
+		}
+	}
+}
+
+type callSite struct {
+	file string
+	line int
+}
+
+func getSiteWarnLimiter(site callSite, limit int, interval time.Duration) *WarnLimiter {
+	warnLimitersMu.Lock()
+	defer warnLimitersMu.Unlock()
+
+	l, ok := siteWarnLimiters[site]
+	if !ok {
+		l = NewWarnLimiter(fmt.Sprintf("%s:%d", filepath.Base(site.file), site.line), limit, interval)
+		siteWarnLimiters[site] = l
+	}
+	return l
+}
+
+// WarnOncef is the same as Warnf, except it prints only the first warning from each call site.
+func WarnOncef(format string, args ...interface{}) {
+	file, line := getSourceInfo(2)
+	getSiteWarnLimiter(callSite{file, line}, 1, 0).Warnf(format, args...)
+}
+
+// WarnLimitedf is the same as Warnf, except it prints up to limit warnings per interval from
+// each call site. The limit and the interval used on the first call from a site are used.
+func WarnLimitedf(limit int, interval time.Duration, format string, args ...interface{}) {
+	file, line := getSourceInfo(2)
+	getSiteWarnLimiter(callSite{file, line}, limit, interval).Warnf(format, args...)
+}
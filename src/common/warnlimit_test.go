@@ -0,0 +1,67 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarnOncef(t *testing.T) {
+	withLogSettings(t)
+	SetLogLevel(LevelWarn)
+	bin := MustGetBinName()
+
+	out := captureStderr(t, func() {
+		for i := 0; i < 3; i++ {
+			WarnOncef("a%d", i)
+			WarnOncef("b%d", i)
+		}
+	})
+	assert.Equal(t, bin+": a0\n"+bin+": b0\n", out)
+
+	_, line := GetSourceInfo()
+	out = captureStderr(t, RunAtExits)
+	assert.Equal(t, fmt.Sprintf("%s: 2 warning(s) from warnlimit_test.go:%d suppressed\n%s: 2 warning(s) from warnlimit_test.go:%d suppressed\n",
+		bin, line-6, bin, line-5), out)
+
+	// Counts are reset after the summary.
+	assert.Equal(t, "", captureStderr(t, PrintSuppressedWarnings))
+}
+
+func TestWarnLimiter(t *testing.T) {
+	withLogSettings(t)
+	SetLogLevel(LevelWarn)
+	bin := MustGetBinName()
+
+	l := NewWarnLimiter("test", 2, 100*time.Millisecond)
+	out := captureStderr(t, func() {
+		for i := 0; i < 5; i++ {
+			l.Warnf("x%d", i)
+		}
+		time.Sleep(150 * time.Millisecond)
+		assert.True(t, l.Warnf("y"))
+		assert.Equal(t, 3, l.Suppressed())
+	})
+	assert.Equal(t, bin+": x0\n"+bin+": x1\n"+bin+": y\n", out)
+
+	out = captureStderr(t, PrintSuppressedWarnings)
+	assert.Equal(t, bin+": 3 warning(s) from test suppressed\n", out)
+	assert.Equal(t, 0, l.Suppressed())
+}
+
+func TestWarnLimitedf(t *testing.T) {
+	withLogSettings(t)
+	SetLogLevel(LevelWarn)
+
+	out := captureStderr(t, func() {
+		for i := 0; i < 10; i++ {
+			WarnLimitedf(3, 0, "x%d", i)
+		}
+		PrintSuppressedWarnings()
+	})
+	assert.Equal(t, 4, strings.Count(out, "\n"))
+	assert.Contains(t, out, "7 warning(s) from warnlimit_test.go:")
+}
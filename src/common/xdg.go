@@ -0,0 +1,106 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// getXdgBaseDir returns the base directory from an XDG environmental variable, or the fallback
+// under the home directory. As the spec says, relative paths are ignored.
+func getXdgBaseDir(env string, homeRelative ...string) string {
+	if dir := os.Getenv(env); dir != "" && filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(append([]string{MustGetHome()}, homeRelative...)...)
+}
+
+func ensureDir(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("unable to create directory %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// GetConfigDir returns $XDG_CONFIG_HOME/BINNAME (defaulting to ~/.config/BINNAME), creating it
+// if needed.
+func GetConfigDir() (string, error) {
+	return ensureDir(filepath.Join(getXdgBaseDir("XDG_CONFIG_HOME", ".config"), MustGetBinName()))
+}
+
+// GetCacheDir returns $XDG_CACHE_HOME/BINNAME (defaulting to ~/.cache/BINNAME), creating it
+// if needed.
+func GetCacheDir() (string, error) {
+	return ensureDir(filepath.Join(getXdgBaseDir("XDG_CACHE_HOME", ".cache"), MustGetBinName()))
+}
+
+// GetDataDir returns $XDG_DATA_HOME/BINNAME (defaulting to ~/.local/share/BINNAME), creating it
+// if needed.
+func GetDataDir() (string, error) {
+	return ensureDir(filepath.Join(getXdgBaseDir("XDG_DATA_HOME", ".local", "share"), MustGetBinName()))
+}
+
+// GetStateDir returns $XDG_STATE_HOME/BINNAME (defaulting to ~/.local/state/BINNAME), creating it
+// if needed.
+func GetStateDir() (string, error) {
+	return ensureDir(filepath.Join(getXdgBaseDir("XDG_STATE_HOME", ".local", "state"), MustGetBinName()))
+}
+
+// GetRuntimeDir returns $XDG_RUNTIME_DIR/BINNAME, creating it if needed. If XDG_RUNTIME_DIR isn't set,
+// it falls back to a per-user directory under os.TempDir().
+func GetRuntimeDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" || !filepath.IsAbs(base) {
+		Debugf("XDG_RUNTIME_DIR not set, using %s", os.TempDir())
+		dir, err := ensureDir(filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d", MustGetBinName(), os.Getuid())))
+		if err != nil {
+			return "", err
+		}
+		// The directory is in a shared location, so make sure it's not someone else's.
+		st, err := os.Lstat(dir)
+		if err != nil {
+			return "", err
+		}
+		if sys, ok := st.Sys().(*syscall.Stat_t); !st.IsDir() || (ok && int(sys.Uid) != os.Getuid()) || st.Mode().Perm() != 0700 {
+			return "", fmt.Errorf("runtime directory %s has unexpected owner or permissions", dir)
+		}
+		return dir, nil
+	}
+	return ensureDir(filepath.Join(base, MustGetBinName()))
+}
+
+// MustGetConfigDir is the same as GetConfigDir, except it calls Fatal on error.
+func MustGetConfigDir() string {
+	dir, err := GetConfigDir()
+	Checke(err)
+	return dir
+}
+
+// MustGetCacheDir is the same as GetCacheDir, except it calls Fatal on error.
+func MustGetCacheDir() string {
+	dir, err := GetCacheDir()
+	Checke(err)
+	return dir
+}
+
+// MustGetDataDir is the same as GetDataDir, except it calls Fatal on error.
+func MustGetDataDir() string {
+	dir, err := GetDataDir()
+	Checke(err)
+	return dir
+}
+
+// MustGetStateDir is the same as GetStateDir, except it calls Fatal on error.
+func MustGetStateDir() string {
+	dir, err := GetStateDir()
+	Checke(err)
+	return dir
+}
+
+// MustGetRuntimeDir is the same as GetRuntimeDir, except it calls Fatal on error.
+func MustGetRuntimeDir() string {
+	dir, err := GetRuntimeDir()
+	Checke(err)
+	return dir
+}
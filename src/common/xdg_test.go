@@ -0,0 +1,64 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXdgDirs(t *testing.T) {
+	bin := MustGetBinName()
+	base := t.TempDir()
+
+	for _, c := range []struct {
+		env string
+		get func() (string, error)
+	}{
+		{"XDG_CONFIG_HOME", GetConfigDir},
+		{"XDG_CACHE_HOME", GetCacheDir},
+		{"XDG_DATA_HOME", GetDataDir},
+		{"XDG_STATE_HOME", GetStateDir},
+		{"XDG_RUNTIME_DIR", GetRuntimeDir},
+	} {
+		t.Setenv(c.env, filepath.Join(base, c.env))
+		dir, err := c.get()
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(base, c.env, bin), dir)
+
+		st, err := os.Stat(dir)
+		assert.NoError(t, err)
+		assert.True(t, st.IsDir())
+		assert.Equal(t, os.FileMode(0700), st.Mode().Perm())
+	}
+}
+
+func TestXdgDirsFallback(t *testing.T) {
+	bin := MustGetBinName()
+	home := t.TempDir()
+
+	orig := cachedHome
+	cachedHome = home
+	defer func() { cachedHome = orig }()
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "relative/path")
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
+	assert.Equal(t, filepath.Join(home, ".config", bin), MustGetConfigDir())
+	assert.Equal(t, filepath.Join(home, ".cache", bin), MustGetCacheDir())
+	assert.Equal(t, filepath.Join(home, ".local", "share", bin), MustGetDataDir())
+	assert.Equal(t, filepath.Join(home, ".local", "state", bin), MustGetStateDir())
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	dir := MustGetRuntimeDir()
+	assert.Equal(t, tmp, filepath.Dir(dir))
+
+	assert.NoError(t, os.Chmod(dir, 0777))
+	_, err := GetRuntimeDir()
+	assert.ErrorContains(t, err, "unexpected owner or permissions")
+}
@@ -2,16 +2,12 @@ package daemon
 
 import (
 	"fmt"
-	"github.com/omakoto/go-common/src/fileutils"
 	"github.com/omakoto/go-common/src/must"
-	"github.com/omakoto/go-common/src/textio"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
@@ -20,6 +16,13 @@ import (
 
 const DaemonMarker = "__DAEMON_MARKER__"
 
+// startTimeout is how long the parent process waits for the daemon to write the PID file.
+const startTimeout = 5 * time.Second
+
+// pidLock is the lock of the PID file held by the daemon process. It's kept here to prevent
+// the file from being closed by the finalizer.
+var pidLock *os.File
+
 // Options for Start and StartWithOptions.
 type Options struct {
 	Cwd         string
@@ -28,6 +31,18 @@ type Options struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Detach makes the daemon run in a new session without the controlling terminal, with stdin
+	// from /dev/null, and stdout and stderr redirected to LogFilename. Stdin, Stdout and Stderr
+	// are ignored.
+	Detach bool
+
+	// LogFilename is the file the daemon's stdout and stderr are appended to, when Detach is set.
+	// Defaults to "daemon.log" in the state directory. (See common.GetStateDir)
+	LogFilename string
+
+	// Umask is the umask the daemon sets, if not nil.
+	Umask *int
 }
 
 type DaemonOptions = Options // For backward compatibility
@@ -40,6 +55,13 @@ func GetDefaultOptions() Options {
 	}
 }
 
+func (o *Options) getLogFile() string {
+	if o.LogFilename != "" {
+		return o.LogFilename
+	}
+	return filepath.Join(common.MustGetStateDir(), "daemon.log")
+}
+
 func (o *Options) getPidFile() string {
 	if o.PidFilename != "" {
 		return o.PidFilename
@@ -68,87 +90,137 @@ func StartWithOptions(options Options) bool {
 
 // IsRunning returns whether the daemon is running or not.
 func IsRunning() bool {
-	return isRunning(getPid(GetDefaultOptions()))
+	return IsRunningWithOptions(GetDefaultOptions())
 }
 
 // IsRunningWithOptions returns whether the daemon is running or not.
+// The PID file is verified with the process start time and the executable, and removed if it's stale.
 func IsRunningWithOptions(options Options) bool {
-	return isRunning(getPid(options))
+	return getRunning(options.getPidFile()) != nil
 }
 
 func doParent(options Options) {
-	if isRunning(getPid(options)) {
+	pidFile := options.getPidFile()
+	if getRunning(pidFile) != nil {
 		common.Panicf("Daemon is already running: options=%v", options)
 	}
 	bin := must.Must2(filepath.Abs(os.Args[0]))
 
-	pidFile := options.getPidFile()
-
 	os.Setenv(DaemonMarker, "x")
 	cmd := exec.Command(bin, os.Args[1:]...)
-	cmd.Stdin = options.Stdin
-	cmd.Stdout = options.Stdout
-	cmd.Stderr = options.Stderr
+	if options.Detach {
+		devNull, err := os.Open(os.DevNull)
+		common.Check(err, "failed to open "+os.DevNull)
+		defer devNull.Close()
+
+		logFile := options.getLogFile()
+		log, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		common.Checkf(err, "failed to open log file %s", logFile)
+		defer log.Close()
+
+		cmd.Stdin = devNull
+		cmd.Stdout = log
+		cmd.Stderr = log
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		common.Debugf("Daemon log file: %s", logFile)
+	} else {
+		cmd.Stdin = options.Stdin
+		cmd.Stdout = options.Stdout
+		cmd.Stderr = options.Stderr
+	}
 
 	common.Debugf("Spawning daemon... %v\n", cmd)
 	err := cmd.Start()
 	common.Check(err, "failed to spawn a daemon process")
 
-	pid := cmd.Process.Pid
-	must.Must(textio.WriteStringToFile(pidFile, strconv.Itoa(pid), 0600))
+	waitForChild(cmd, pidFile)
 }
 
-func doChild(options Options) {
-	os.Unsetenv(DaemonMarker)
-
-	os.Chdir(options.Cwd)
-
-	signal.Ignore(syscall.SIGHUP)
+// waitForChild waits until the child process writes the PID file, or exits.
+func waitForChild(cmd *exec.Cmd, pidFile string) {
+	pid := cmd.Process.Pid
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
 
-	fmt.Printf("Daemon started with pid %d\n", os.Getpid())
+	timeout := time.After(startTimeout)
+	for {
+		if p, err := readPidFileQuietly(pidFile); err == nil && p != nil && p.pid == pid {
+			common.Debugf("Daemon started with pid %d", pid)
+			return
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				// The daemon may have started and finished between two polls.
+				common.Debugf("Daemon with pid %d already exited successfully", pid)
+				return
+			}
+			common.Panicf("Daemon exited while starting: %v", err)
+		case <-timeout:
+			common.Warnf("Daemon didn't write the PID file %s in %v", pidFile, startTimeout)
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
 }
 
-func getPid(options Options) int {
-	pidFile := options.getPidFile()
-
-	if !fileutils.FileExists(pidFile) {
-		common.Debugf("PID file %s doesn't exist", pidFile)
-		return -1
+// lockAndWritePidFile acquires the lock of the PID file and writes the current PID to it.
+// If another daemon holds the lock, it retries for a while, in case another process is only
+// checking a stale PID file.
+func lockAndWritePidFile(pidFile string) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		if i > 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if pidLock, err = lockPidFile(pidFile); err != errLocked {
+			break
+		}
 	}
-	pid, err := textio.ReadStringFromFile(pidFile)
 	if err != nil {
-		common.Warnf("PID file %s not readable: %s", pidFile, err)
-		return -1
+		return err
 	}
-	pid = textio.StringChomp(pid)
-	ipid, err := strconv.Atoi(pid)
+	p, err := getProcInfo(os.Getpid())
 	if err != nil {
-		common.Warnf("Invalid PID format in file %s: '%s'", pidFile, pid)
-		return -1
+		return err
 	}
-
-	common.Debugf("Previous PID: %d", ipid)
-	return ipid
+	if err := writePidFile(pidFile, p); err != nil {
+		return err
+	}
+	common.AtExit(func() {
+		// Remove the file only if it's still ours.
+		if cur, _ := readPidFile(pidFile); cur != nil && *cur == *p {
+			os.Remove(pidFile)
+		}
+	})
+	return nil
 }
 
-func isRunning(pid int) bool {
-	if pid < 0 {
-		return false
-	}
-	proc := fmt.Sprintf("/proc/%d/stat", pid)
-	stat, err := textio.ReadStringFromFile(proc)
-	if err != nil {
-		common.Debugf("Failed to read %s: %s", proc, err)
-		return false
+func doChild(options Options) {
+	os.Unsetenv(DaemonMarker)
+
+	// The PID file is removed at exit, which happens after changing the directory.
+	pidFile := must.Must2(filepath.Abs(options.getPidFile()))
+	if err := lockAndWritePidFile(pidFile); err != nil {
+		if err == errLocked {
+			common.Warnf("Daemon is already running")
+		} else {
+			common.Warnf("Failed to write PID file %s: %s", pidFile, err)
+		}
+		os.Exit(1)
 	}
-	stat = strings.Trim(stat, " \n")
-	fields := strings.Split(stat, " ")
-	if len(fields) < 2 {
-		common.Debugf("Invalid format from %s: \"%s\"", proc, stat)
-		return false
+
+	os.Chdir(options.Cwd)
+
+	if options.Umask != nil {
+		syscall.Umask(*options.Umask)
 	}
-	myName := filepath.Base(os.Args[0])
-	return fields[1] == "("+myName+")"
+
+	signal.Ignore(syscall.SIGHUP)
+
+	fmt.Printf("Daemon started with pid %d\n", os.Getpid())
 }
 
 // Stop stops the daemon if it's running. Returns false if it's running but failed to stop it.
@@ -158,27 +230,28 @@ func Stop() bool {
 
 // StopWithOptions stops the daemon if it's running. Returns false if it's running but failed to stop it.
 func StopWithOptions(options Options) bool {
-	pid := getPid(options)
-	if pid < 0 {
+	p := getRunning(options.getPidFile())
+	if p == nil {
 		return true
 	}
-	if !isRunning(pid) {
-		return true
+	if p.pid == 0 {
+		common.Warnf("Daemon is starting up, and its pid is unknown yet.")
+		return false
 	}
-	err := syscall.Kill(pid, syscall.SIGTERM)
+	err := syscall.Kill(p.pid, syscall.SIGTERM)
 	if err != nil {
-		common.Warnf("Failed to send signal to pid %d: %s", pid, err)
+		common.Warnf("Failed to send signal to pid %d: %s", p.pid, err)
 		return false
 	}
 
 	timeout := time.Now().Add(time.Second * 5)
 	for {
 		time.Sleep(50 * time.Millisecond)
-		if !isRunning(pid) {
+		if !p.alive() {
 			return true
 		}
 		if time.Now().After(timeout) {
-			common.Warnf("Pid %d didn't terminate.", pid)
+			common.Warnf("Pid %d didn't terminate.", p.pid)
 			return false
 		}
 	}
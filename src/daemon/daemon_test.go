@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/omakoto/go-common/src/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const envDaemonHelper = "GO_COMMON_TEST_DAEMON_HELPER"
+
+// TestDetachHelper is executed in a child process by TestDetach. It starts the daemon, which
+// re-executes the same test.
+func TestDetachHelper(t *testing.T) {
+	dir := os.Getenv(envDaemonHelper)
+	if dir == "" {
+		t.Skip("only used as a helper")
+	}
+	umask := 027
+	options := GetDefaultOptions()
+	options.Detach = true
+	options.Umask = &umask
+	options.Cwd = dir
+	options.PidFilename = filepath.Join(dir, "pid.txt")
+	if StartWithOptions(options) {
+		return
+	}
+
+	// In the daemon.
+	sid, _, _ := syscall.RawSyscall(syscall.SYS_GETSID, 0, 0, 0)
+	stdin, _ := os.Stdin.Stat()
+	devNull, _ := os.Stat(os.DevNull)
+	cwd, _ := os.Getwd()
+	fmt.Printf("sid=%d pid=%d\n", sid, os.Getpid())
+	fmt.Printf("stdin-devnull=%v\n", os.SameFile(stdin, devNull))
+	fmt.Printf("cwd=%s\n", cwd)
+	fmt.Fprintf(os.Stderr, "stderr-output\n")
+	_ = os.WriteFile("created.txt", nil, 0666)
+	fmt.Println("done")
+}
+
+func TestDetach(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := t.TempDir()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDetachHelper$")
+	cmd.Env = append(os.Environ(), envDaemonHelper+"="+dir, "XDG_STATE_HOME="+stateDir)
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+
+	// The log file is in the state directory.
+	logFile := filepath.Join(stateDir, filepath.Base(os.Args[0]), "daemon.log")
+	var log string
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(20 * time.Millisecond) {
+		data, _ := os.ReadFile(logFile)
+		log = string(data)
+		if strings.Contains(log, "done\n") {
+			break
+		}
+	}
+	assert.Contains(t, log, "done\n")
+
+	var sid, pid int
+	_, err = fmt.Sscanf(log[strings.Index(log, "sid="):], "sid=%d pid=%d", &sid, &pid)
+	assert.NoError(t, err)
+	assert.Equal(t, pid, sid, "the daemon should be a session leader")
+	assert.Contains(t, log, "stdin-devnull=true\n")
+	assert.Contains(t, log, "cwd="+dir+"\n")
+	assert.Contains(t, log, "stderr-output\n")
+
+	// Not written to the parent's output.
+	assert.NotContains(t, string(out), "stderr-output")
+
+	st, err := os.Stat(filepath.Join(dir, "created.txt"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0640), st.Mode().Perm(), "umask should be applied")
+	}
+}
+
+func TestWaitForChild(t *testing.T) {
+	var buf bytes.Buffer
+	level := common.GetLogLevel()
+	common.SetLogLevel(common.LevelDebug)
+	common.SetLogOutput(&buf)
+	defer func() {
+		common.SetLogLevel(level)
+		common.SetLogOutput(nil)
+	}()
+
+	pidFile := filepath.Join(t.TempDir(), "pid.txt")
+
+	// A daemon that exits successfully without the parent seeing its PID file is fine.
+	cmd := exec.Command("sh", "-c", "sleep 0.2")
+	assert.NoError(t, cmd.Start())
+	waitForChild(cmd, pidFile)
+	assert.NotContains(t, buf.String(), "doesn't exist", "polling should be quiet")
+
+	cmd = exec.Command("sh", "-c", "exit 3")
+	assert.NoError(t, cmd.Start())
+	assert.PanicsWithValue(t, "Daemon exited while starting: exit status 3", func() {
+		waitForChild(cmd, pidFile)
+	})
+}
@@ -0,0 +1,204 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/omakoto/go-common/src/common"
+)
+
+// procInfo identifies a process. A PID alone isn't enough because PIDs are reused, so
+// it also has the start time and the executable.
+type procInfo struct {
+	pid       int
+	startTime uint64 // In clock ticks since boot, from /proc/PID/stat. 0 if unknown.
+	exe       string // From /proc/PID/exe. Empty if unknown.
+}
+
+// getProcInfo returns the procInfo of a running process.
+func getProcInfo(pid int) (*procInfo, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The command name may contain spaces and parentheses, so skip to the last ')'.
+	s := string(stat)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid format in /proc/%d/stat", pid)
+	}
+	// The fields after the command name start from the 3rd field (state), and the start time is
+	// the 22nd field.
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("invalid format in /proc/%d/stat", pid)
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time in /proc/%d/stat: %w", pid, err)
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The executable may have been replaced, e.g. by an upgrade, while the process is running.
+	exe = strings.TrimSuffix(exe, " (deleted)")
+	return &procInfo{pid: pid, startTime: startTime, exe: exe}, nil
+}
+
+// alive returns whether the process identified by p is still running.
+func (p *procInfo) alive() bool {
+	cur, err := getProcInfo(p.pid)
+	if err != nil {
+		common.Debugf("Pid %d not running: %s", p.pid, err)
+		return false
+	}
+	if p.startTime == 0 {
+		// PID file written by an older version, which only had the PID. Compare the command name instead.
+		return filepath.Base(cur.exe) == filepath.Base(os.Args[0])
+	}
+	if cur.startTime != p.startTime || cur.exe != p.exe {
+		common.Debugf("Pid %d has been reused: %v", p.pid, cur)
+		return false
+	}
+	return true
+}
+
+func (p *procInfo) String() string {
+	return fmt.Sprintf("%d %d %s\n", p.pid, p.startTime, p.exe)
+}
+
+// readPidFile reads a PID file, which contains "PID START_TIME EXE". Files with only the PID
+// are also accepted. Returns nil if the file doesn't exist.
+func readPidFile(filename string) (*procInfo, error) {
+	p, err := readPidFileQuietly(filename)
+	if p == nil && err == nil {
+		common.Debugf("PID file %s doesn't exist", filename)
+	}
+	return p, err
+}
+
+// readPidFileQuietly is the same as readPidFile, except it doesn't log a missing file, for polling.
+func readPidFileQuietly(filename string) (*procInfo, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(data)), " ", 3)
+	ret := &procInfo{}
+	if ret.pid, err = strconv.Atoi(fields[0]); err != nil || ret.pid <= 0 {
+		return nil, fmt.Errorf("invalid PID format in file %s: '%s'", filename, fields[0])
+	}
+	if len(fields) == 3 {
+		if ret.startTime, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid start time in file %s: '%s'", filename, fields[1])
+		}
+		ret.exe = fields[2]
+	}
+	return ret, nil
+}
+
+// writePidFile atomically writes a PID file, so readers never see a partially written file.
+func writePidFile(filename string, p *procInfo) error {
+	temp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) // No-op after a successful rename.
+
+	if _, err := temp.WriteString(p.String()); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
+func getLockFile(pidFile string) string {
+	return pidFile + ".lock"
+}
+
+// errLocked is returned by lockPidFile when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// lockPidFile acquires an exclusive lock for a PID file, which is held until the returned file
+// is closed, or the process exits. The lock is on a separate file, because the PID file itself
+// is replaced when written.
+func lockPidFile(pidFile string) (*os.File, error) {
+	file, err := os.OpenFile(getLockFile(pidFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// isLocked returns whether a process holds the lock for a PID file.
+func isLocked(pidFile string) bool {
+	file, err := os.Open(getLockFile(pidFile))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return false
+	}
+	return errors.Is(err, syscall.EWOULDBLOCK)
+}
+
+// getRunning returns the procInfo of the running daemon, or nil if it's not running. The pid is
+// 0 if the daemon is starting up and hasn't written the PID file yet.
+// A stale PID file is removed.
+func getRunning(pidFile string) *procInfo {
+	p, err := readPidFile(pidFile)
+	if err != nil {
+		common.Warnf("%s", err)
+	}
+	if p != nil && p.alive() {
+		common.Debugf("Daemon running: pid %d", p.pid)
+		return p
+	}
+	if p == nil && err == nil {
+		if isLocked(pidFile) {
+			common.Debugf("PID file %s is locked", pidFile)
+			return &procInfo{}
+		}
+		return nil
+	}
+
+	// Remove the stale file while holding the lock, so a new daemon won't write the file in between.
+	lock, lerr := lockPidFile(pidFile)
+	if lerr == errLocked {
+		common.Debugf("PID file %s is locked", pidFile)
+		return &procInfo{}
+	}
+	if lerr != nil {
+		common.Warnf("Failed to lock PID file: %s", lerr)
+		return nil
+	}
+	defer lock.Close()
+
+	common.Debugf("Removing stale PID file %s", pidFile)
+	if err := os.Remove(pidFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		common.Warnf("Failed to remove stale PID file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPidFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid.txt")
+
+	p, err := readPidFile(pidFile)
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Nil(t, getRunning(pidFile))
+
+	self, err := getProcInfo(os.Getpid())
+	assert.NoError(t, err)
+	assert.True(t, self.alive())
+
+	assert.NoError(t, writePidFile(pidFile, self))
+	p, err = readPidFile(pidFile)
+	assert.NoError(t, err)
+	assert.Equal(t, *self, *p)
+	assert.Equal(t, *self, *getRunning(pidFile))
+
+	// Same PID, but a different start time, as if the PID has been reused.
+	reused := *self
+	reused.startTime++
+	assert.False(t, reused.alive())
+	assert.NoError(t, writePidFile(pidFile, &reused))
+	assert.Nil(t, getRunning(pidFile))
+	_, err = os.Stat(pidFile)
+	assert.True(t, os.IsNotExist(err), "stale PID file should be removed")
+
+	assert.NoError(t, os.WriteFile(pidFile, []byte("abc\n"), 0600))
+	_, err = readPidFile(pidFile)
+	assert.Error(t, err)
+}
+
+func TestPidFileLock(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid.txt")
+	assert.False(t, isLocked(pidFile))
+
+	lock, err := lockPidFile(pidFile)
+	assert.NoError(t, err)
+	assert.True(t, isLocked(pidFile))
+
+	_, err = lockPidFile(pidFile)
+	assert.Equal(t, errLocked, err)
+
+	// No PID file yet, but locked: the daemon is starting up.
+	p := getRunning(pidFile)
+	if assert.NotNil(t, p) {
+		assert.Equal(t, 0, p.pid)
+	}
+
+	assert.NoError(t, lock.Close())
+	assert.False(t, isLocked(pidFile))
+	assert.Nil(t, getRunning(pidFile))
+}
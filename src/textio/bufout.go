@@ -11,7 +11,7 @@ var (
 )
 
 func init() {
-	common.AtExit(func() {
+	common.AtExitWithOptions(common.ExitHookOptions{Name: "BufferedStdout", Priority: common.ExitPriorityLate}, func() {
 		BufferedStdout.Flush()
 	})
 }
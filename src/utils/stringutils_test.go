@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, EditDistance("", ""))
+	assert.Equal(t, 3, EditDistance("", "abc"))
+	assert.Equal(t, 3, EditDistance("abc", ""))
+	assert.Equal(t, 0, EditDistance("git", "git"))
+	assert.Equal(t, 2, EditDistance("gti", "git"))
+	assert.Equal(t, 1, EditDistance("grpe", "grepe"))
+	assert.Equal(t, 3, EditDistance("kitten", "sitting"))
+	assert.Equal(t, 1, EditDistance("日本", "日本語"))
+}